@@ -15,6 +15,8 @@ package qmgo
 
 import (
 	"context"
+
+	"github.com/qiniu/qmgo/interceptor"
 	opts "github.com/qiniu/qmgo/options"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
@@ -31,6 +33,24 @@ type Aggregate struct {
 	pipeline   interface{}
 	collection *mongo.Collection
 	options    []opts.AggregateOptions
+	// softDelete is the bson key of the deletion time if soft delete is enabled
+	softDelete  string
+	withDeleted bool
+}
+
+// WithDeleted makes the aggregate include the soft deleted documents
+func (a *Aggregate) WithDeleted() AggregateI {
+	newA := *a
+	newA.withDeleted = true
+	return &newA
+}
+
+// stages returns the pipeline to run, which excludes the soft deleted documents first if soft delete is enabled
+func (a *Aggregate) stages() (interface{}, error) {
+	if a.softDelete == "" || a.withDeleted {
+		return a.pipeline, nil
+	}
+	return excludeDeletedPipeline(a.pipeline, a.softDelete)
 }
 
 // All iterates the cursor from aggregate and decodes each document into results.
@@ -39,11 +59,21 @@ func (a *Aggregate) All(results interface{}) error {
 	if len(a.options) > 0 {
 		opts = a.options[0].AggregateOptions
 	}
-	c, err := a.collection.Aggregate(a.ctx, a.pipeline, opts)
+	pipeline, err := a.stages()
 	if err != nil {
 		return err
 	}
-	return c.All(a.ctx, results)
+	return intercept(a.ctx, a.collection, interceptor.Aggregate, pipeline, func(ctx context.Context, op *interceptor.Operation) error {
+		c, err := a.collection.Aggregate(ctx, pipeline, opts)
+		if err != nil {
+			return err
+		}
+		if err = c.All(ctx, results); err != nil {
+			return err
+		}
+		op.Returned = resultLen(results)
+		return nil
+	})
 }
 
 // One iterates the cursor from aggregate and decodes current document into result.
@@ -52,23 +82,30 @@ func (a *Aggregate) One(result interface{}) error {
 	if len(a.options) > 0 {
 		opts = a.options[0].AggregateOptions
 	}
-	c, err := a.collection.Aggregate(a.ctx, a.pipeline, opts)
+	pipeline, err := a.stages()
 	if err != nil {
 		return err
 	}
-	cr := Cursor{
-		ctx:    a.ctx,
-		cursor: c,
-		err:    err,
-	}
-	defer cr.Close()
-	if !cr.Next(result) {
-		if err := cr.Err(); err != nil {
+	return intercept(a.ctx, a.collection, interceptor.Aggregate, pipeline, func(ctx context.Context, op *interceptor.Operation) error {
+		c, err := a.collection.Aggregate(ctx, pipeline, opts)
+		if err != nil {
 			return err
 		}
-		return ErrNoSuchDocuments
-	}
-	return err
+		cr := Cursor{
+			ctx:    ctx,
+			cursor: c,
+			err:    err,
+		}
+		defer cr.Close()
+		if !cr.Next(result) {
+			if err := cr.Err(); err != nil {
+				return err
+			}
+			return ErrNoSuchDocuments
+		}
+		op.Returned = 1
+		return nil
+	})
 }
 
 // Iter return the cursor after aggregate
@@ -78,12 +115,25 @@ func (a *Aggregate) Iter() CursorI {
 }
 
 // Cursor return the cursor after aggregate
+// The interceptors see the aggregate command only, the Returned of the operation is the size of the first batch,
+// the later getMore commands are not intercepted
 func (a *Aggregate) Cursor() CursorI {
 	opts := options.Aggregate()
 	if len(a.options) > 0 {
 		opts = a.options[0].AggregateOptions
 	}
-	c, err := a.collection.Aggregate(a.ctx, a.pipeline, opts)
+	pipeline, err := a.stages()
+	if err != nil {
+		return &Cursor{err: err}
+	}
+	var c *mongo.Cursor
+	err = intercept(a.ctx, a.collection, interceptor.Aggregate, pipeline, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		if c, err = a.collection.Aggregate(ctx, pipeline, opts); err != nil {
+			return err
+		}
+		op.Returned = int64(c.RemainingBatchLength())
+		return nil
+	})
 	return &Cursor{
 		ctx:    a.ctx,
 		cursor: c,
@@ -121,3 +121,78 @@ func TestAggregate(t *testing.T) {
 	ast.Error(cli.Aggregate(context.Background(), Pipeline{matchStage, groupStage}).One(&showsWithInfo))
 
 }
+
+func TestPipelineBuilder(t *testing.T) {
+	ast := require.New(t)
+
+	p := NewPipeline().
+		Match(bson.M{"age": bson.M{"$gt": 11}}).
+		Group("$name", AccSum("total", "$age"), AccAvg("avg", "$age"), AccPush("ages", "$age"), AccAddToSet("ids", "$_id")).
+		Sort("-total", "_id").
+		Skip(1).Limit(2)
+	ast.Equal(Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{"age": bson.M{"$gt": 11}}}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$name"},
+			{Key: "total", Value: bson.D{{Key: "$sum", Value: "$age"}}},
+			{Key: "avg", Value: bson.D{{Key: "$avg", Value: "$age"}}},
+			{Key: "ages", Value: bson.D{{Key: "$push", Value: "$age"}}},
+			{Key: "ids", Value: bson.D{{Key: "$addToSet", Value: "$_id"}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "total", Value: int32(-1)}, {Key: "_id", Value: int32(1)}}}},
+		bson.D{{Key: "$skip", Value: int64(1)}},
+		bson.D{{Key: "$limit", Value: int64(2)}},
+	}, p)
+
+	p = NewPipeline().Lookup("orders", "_id", "userId", "orders").Unwind("orders").UnwindPreserveEmpty("$tags").
+		Facet(map[string]Pipeline{"total": NewPipeline().Count("n"), "first": NewPipeline().Limit(1)})
+	ast.Equal(Pipeline{
+		bson.D{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "orders"},
+			{Key: "localField", Value: "_id"},
+			{Key: "foreignField", Value: "userId"},
+			{Key: "as", Value: "orders"},
+		}}},
+		bson.D{{Key: "$unwind", Value: "$orders"}},
+		bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$tags"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "first", Value: Pipeline{bson.D{{Key: "$limit", Value: int64(1)}}}},
+			{Key: "total", Value: Pipeline{bson.D{{Key: "$count", Value: "n"}}}},
+		}}},
+	}, p)
+
+	// sort without fields is no-op
+	ast.Equal(Pipeline{bson.D{{Key: "$limit", Value: int64(1)}}}, NewPipeline().Sort().Limit(1))
+
+	// the pipelines branched from the same base are independent
+	base := NewPipeline().Match(bson.M{"a": 1}).Match(bson.M{"b": 1}).Match(bson.M{"c": 1})
+	x := base.Sort("x")
+	y := base.Limit(5)
+	ast.Len(base, 3)
+	ast.Equal(bson.D{{Key: "$sort", Value: bson.D{{Key: "x", Value: int32(1)}}}}, x[3])
+	ast.Equal(bson.D{{Key: "$limit", Value: int64(5)}}, y[3])
+}
+
+func TestAggregate_Pipeline(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+
+	docs := []interface{}{
+		QueryTestItem{Id: primitive.NewObjectID(), Name: "Alice", Age: 10},
+		QueryTestItem{Id: primitive.NewObjectID(), Name: "Alice", Age: 12},
+		QueryTestItem{Id: primitive.NewObjectID(), Name: "Lucas", Age: 33},
+		QueryTestItem{Id: primitive.NewObjectID(), Name: "Lucas", Age: 22},
+	}
+	_, err := cli.InsertMany(context.Background(), docs)
+	ast.NoError(err)
+
+	var res []bson.M
+	p := NewPipeline().Match(bson.M{"age": bson.M{"$gt": 11}}).Group("$name", AccSum("total", "$age")).Sort("-total")
+	ast.NoError(cli.Aggregate(context.Background(), p).All(&res))
+	ast.Len(res, 2)
+	ast.Equal("Lucas", res[0]["_id"])
+	ast.Equal(int32(55), res[0]["total"])
+	ast.Equal("Alice", res[1]["_id"])
+}
@@ -15,7 +15,15 @@ package qmgo
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"sync"
 
+	"github.com/qiniu/qmgo/hook"
+	"github.com/qiniu/qmgo/interceptor"
+	"github.com/qiniu/qmgo/operator"
+	opts "github.com/qiniu/qmgo/options"
+	"github.com/qiniu/qmgo/validator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -49,8 +57,12 @@ type BulkResult struct {
 //
 // Notes:
 //
-// Individual operations inside a bulk do not trigger middlewares or hooks
-// at present.
+// The Before* middlewares of individual operations, like hooks, default fields
+// and validation, and the hook functions of collection are called by Run with its ctx
+// before the writes, and the After* ones are called after the writes succeed. The ctx carries
+// the hook.Operation of every operation. They can be skipped by BulkOperationOptions.SkipMiddleware.
+// If a Before* middleware fails, Run returns the error without any write, and the
+// middlewares of the operations prepared already are not called again by the next Run.
 //
 // Different from original mgo, the qmgo implementation of Bulk does not emulate
 // bulk operations individually on old versions of MongoDB servers that do not
@@ -62,7 +74,26 @@ type Bulk struct {
 	coll *Collection
 
 	queue   []mongo.WriteModel
+	hooks   []bulkHook
 	ordered *bool
+
+	batchSize   int
+	concurrency int
+	progress    func(done, total int)
+}
+
+// bulkHook is the middlewares and hooks of one queued operation
+type bulkHook struct {
+	doc    interface{}
+	before operator.OpType
+	after  operator.OpType
+	hook   interface{}
+	skip   bool
+
+	// op is the descriptor of operation for hooks, the update is the one of update operations
+	op       *hook.Operation
+	upsert   bool
+	prepared bool
 }
 
 // Bulk returns a new context for preparing bulk execution of operations.
@@ -83,103 +114,323 @@ func (b *Bulk) SetOrdered(ordered bool) *Bulk {
 	return b
 }
 
+// SetBatchSize splits the queue into batches of at most n operations, every batch is sent in one bulk write.
+// Default is 0, that is all operations are sent in one bulk write.
+func (b *Bulk) SetBatchSize(n int) *Bulk {
+	b.batchSize = n
+	return b
+}
+
+// SetConcurrency sets the number of batches run in parallel if the bulk is unordered.
+// The batches of ordered bulk are always run in order. Default is 1.
+func (b *Bulk) SetConcurrency(k int) *Bulk {
+	b.concurrency = k
+	return b
+}
+
+// SetProgress sets fn called after every batch succeeds, with the number of operations done and queued.
+// The calls are serialized even if the batches run in parallel.
+func (b *Bulk) SetProgress(fn func(done, total int)) *Bulk {
+	b.progress = fn
+	return b
+}
+
 // InsertOne queues an InsertOne operation for bulk execution.
-func (b *Bulk) InsertOne(doc interface{}) *Bulk {
+// The BeforeInsert middlewares work on the doc, or the Hook in opts if set
+func (b *Bulk) InsertOne(doc interface{}, opts ...opts.BulkOperationOptions) *Bulk {
 	wm := mongo.NewInsertOneModel().SetDocument(doc)
-	b.queue = append(b.queue, wm)
-	return b
+	return b.enqueue(wm, doc, operator.BeforeInsert, operator.AfterInsert, doc, nil, nil, false, opts)
 }
 
 // Remove queues a Remove operation for bulk execution.
-func (b *Bulk) Remove(filter interface{}) *Bulk {
-	wm := mongo.NewDeleteOneModel().SetFilter(filter)
-	b.queue = append(b.queue, wm)
-	return b
+// The BeforeRemove middlewares work on the Hook in opts if set
+// If soft delete is enabled on the collection, the document is soft deleted like Collection.Remove,
+// and it is counted in the ModifiedCount of result instead of DeletedCount
+func (b *Bulk) Remove(filter interface{}, opts ...opts.BulkOperationOptions) *Bulk {
+	var wm mongo.WriteModel = mongo.NewDeleteOneModel().SetFilter(filter)
+	if b.coll.softDelete != "" {
+		wm = mongo.NewUpdateOneModel().SetFilter(excludeDeleted(filter, b.coll.softDelete)).SetUpdate(b.coll.softDeleteUpdate())
+	}
+	return b.enqueue(wm, nil, operator.BeforeRemove, operator.AfterRemove, nil, filter, nil, false, opts)
 }
 
 // RemoveId queues a RemoveId operation for bulk execution.
-func (b *Bulk) RemoveId(id interface{}) *Bulk {
-	b.Remove(bson.M{"_id": id})
+func (b *Bulk) RemoveId(id interface{}, opts ...opts.BulkOperationOptions) *Bulk {
+	b.Remove(bson.M{"_id": id}, opts...)
 	return b
 }
 
 // RemoveAll queues a RemoveAll operation for bulk execution.
-func (b *Bulk) RemoveAll(filter interface{}) *Bulk {
-	wm := mongo.NewDeleteManyModel().SetFilter(filter)
-	b.queue = append(b.queue, wm)
-	return b
+// If soft delete is enabled on the collection, the documents are soft deleted, the same as Remove
+func (b *Bulk) RemoveAll(filter interface{}, opts ...opts.BulkOperationOptions) *Bulk {
+	var wm mongo.WriteModel = mongo.NewDeleteManyModel().SetFilter(filter)
+	if b.coll.softDelete != "" {
+		wm = mongo.NewUpdateManyModel().SetFilter(excludeDeleted(filter, b.coll.softDelete)).SetUpdate(b.coll.softDeleteUpdate())
+	}
+	return b.enqueue(wm, nil, operator.BeforeRemove, operator.AfterRemove, nil, filter, nil, false, opts)
 }
 
 // Upsert queues an Upsert operation for bulk execution.
 // The replacement should be document without operator
-func (b *Bulk) Upsert(filter interface{}, replacement interface{}) *Bulk {
+// The BeforeUpsert middlewares work on the replacement, or the Hook in opts if set
+func (b *Bulk) Upsert(filter interface{}, replacement interface{}, opts ...opts.BulkOperationOptions) *Bulk {
 	wm := mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(replacement).SetUpsert(true)
-	b.queue = append(b.queue, wm)
-	return b
+	return b.enqueue(wm, replacement, operator.BeforeUpsert, operator.AfterUpsert, replacement, filter, replacement, true, opts)
 }
 
 // UpsertOne queues an UpsertOne operation for bulk execution.
 // The update should contain operator
-func (b *Bulk) UpsertOne(filter interface{}, update interface{}) *Bulk {
+// The BeforeUpdate middlewares work on the Hook in opts if set
+func (b *Bulk) UpsertOne(filter interface{}, update interface{}, opts ...opts.BulkOperationOptions) *Bulk {
 	wm := mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
-	b.queue = append(b.queue, wm)
-	return b
+	return b.enqueue(wm, nil, operator.BeforeUpdate, operator.AfterUpdate, nil, filter, update, true, opts)
 }
 
 // UpsertId queues an UpsertId operation for bulk execution.
 // The replacement should be document without operator
-func (b *Bulk) UpsertId(id interface{}, replacement interface{}) *Bulk {
-	b.Upsert(bson.M{"_id": id}, replacement)
+func (b *Bulk) UpsertId(id interface{}, replacement interface{}, opts ...opts.BulkOperationOptions) *Bulk {
+	b.Upsert(bson.M{"_id": id}, replacement, opts...)
 	return b
 }
 
 // UpdateOne queues an UpdateOne operation for bulk execution.
 // The update should contain operator
-func (b *Bulk) UpdateOne(filter interface{}, update interface{}) *Bulk {
+// The BeforeUpdate middlewares work on the Hook in opts if set
+func (b *Bulk) UpdateOne(filter interface{}, update interface{}, opts ...opts.BulkOperationOptions) *Bulk {
 	wm := mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update)
-	b.queue = append(b.queue, wm)
-	return b
+	return b.enqueue(wm, nil, operator.BeforeUpdate, operator.AfterUpdate, nil, filter, update, false, opts)
 }
 
 // UpdateId queues an UpdateId operation for bulk execution.
 // The update should contain operator
-func (b *Bulk) UpdateId(id interface{}, update interface{}) *Bulk {
-	b.UpdateOne(bson.M{"_id": id}, update)
+func (b *Bulk) UpdateId(id interface{}, update interface{}, opts ...opts.BulkOperationOptions) *Bulk {
+	b.UpdateOne(bson.M{"_id": id}, update, opts...)
 	return b
 }
 
 // UpdateAll queues an UpdateAll operation for bulk execution.
 // The update should contain operator
-func (b *Bulk) UpdateAll(filter interface{}, update interface{}) *Bulk {
+// The BeforeUpdate middlewares work on the Hook in opts if set
+func (b *Bulk) UpdateAll(filter interface{}, update interface{}, opts ...opts.BulkOperationOptions) *Bulk {
 	wm := mongo.NewUpdateManyModel().SetFilter(filter).SetUpdate(update)
+	return b.enqueue(wm, nil, operator.BeforeUpdate, operator.AfterUpdate, nil, filter, update, false, opts)
+}
+
+// enqueue queues wm with its middlewares and hooks, which are called by Run
+// The Hook in opts replaces h, and is used as doc if there is no document
+func (b *Bulk) enqueue(wm mongo.WriteModel, doc interface{}, before, after operator.OpType, h interface{},
+	filter, update interface{}, upsert bool, opts []opts.BulkOperationOptions) *Bulk {
+	skip := false
+	if len(opts) > 0 {
+		skip = opts[0].SkipMiddleware
+		if opts[0].Hook != nil {
+			h = opts[0].Hook
+			if doc == nil {
+				doc = h
+			}
+		}
+	}
+	op := &hook.Operation{Collection: b.coll.collection.Name(), Filter: filter, Update: update}
 	b.queue = append(b.queue, wm)
+	b.hooks = append(b.hooks, bulkHook{doc: doc, before: before, after: after, hook: h, skip: skip, op: op, upsert: upsert})
 	return b
 }
 
-// Run executes the collected operations in a single bulk operation.
+// prepare calls the before middlewares and hook functions of the queued operations which are not prepared yet,
+// then validates the updates against the model of collection, and sets the update time fields, and the id and
+// create time fields on upsert, of the model in the updates
+func (b *Bulk) prepare(ctx context.Context) error {
+	for i := range b.hooks {
+		bh := &b.hooks[i]
+		if bh.prepared || bh.skip {
+			continue
+		}
+		if err := b.coll.beforeHook(hook.WithOperation(ctx, bh.op), bh.doc, bh.before, bh.hook); err != nil {
+			return err
+		}
+		if bh.before == operator.BeforeUpdate {
+			if err := validator.Update(b.coll.model, bh.op.Update); err != nil {
+				return err
+			}
+			u, err := withUpdateFields(b.coll.registry, b.coll.model, bh.op.Filter, bh.op.Update, bh.upsert)
+			if err != nil {
+				return err
+			}
+			switch wm := b.queue[i].(type) {
+			case *mongo.UpdateOneModel:
+				wm.SetUpdate(u)
+			case *mongo.UpdateManyModel:
+				wm.SetUpdate(u)
+			}
+		}
+		bh.prepared = true
+	}
+	return nil
+}
+
+// Run executes the collected operations in bulk writes, split by SetBatchSize.
+//
+// If the bulk is ordered, batches are run in order and Run stops at the first
+// failed batch, otherwise they are run in parallel by SetConcurrency and all
+// batches are run.
 //
 // A successful call resets the Bulk. If an error is returned, the internal
 // queue of operations is unchanged, containing both successful and failed
-// operations.
+// operations. If writes fail, the result of the succeeded writes is returned
+// with *BulkError, which reports the failed operations.
 func (b *Bulk) Run(ctx context.Context) (*BulkResult, error) {
+	ctx = b.coll.bindSession(ctx)
+	if err := b.prepare(ctx); err != nil {
+		return nil, err
+	}
 	opts := options.BulkWriteOptions{
 		Ordered: b.ordered,
 	}
-	result, err := b.coll.collection.BulkWrite(ctx, b.queue, &opts)
-	if err != nil {
+
+	res := &BulkResult{UpsertedIDs: make(map[int64]interface{})}
+	var (
+		mu      sync.Mutex
+		done    int
+		bulkErr *BulkError
+	)
+	run := func(start, end int) error {
+		result, err := b.runBatch(ctx, b.queue[start:end], &opts)
+		mu.Lock()
+		defer mu.Unlock()
+		res.merge(result, int64(start))
+		if err != nil {
+			if bulkErr == nil {
+				bulkErr = &BulkError{Err: err}
+			}
+			bulkErr.WriteErrors = append(bulkErr.WriteErrors, bulkWriteErrors(err, b.queue, start)...)
+			return err
+		}
+		done += end - start
+		if b.progress != nil {
+			b.progress(done, len(b.queue))
+		}
+		return nil
+	}
+
+	batches := b.batches()
+	if b.ordered == nil || *b.ordered || b.concurrency <= 1 || len(batches) == 1 {
+		for _, batch := range batches {
+			if err := run(batch[0], batch[1]); err != nil && (b.ordered == nil || *b.ordered) {
+				break
+			}
+		}
+	} else {
+		ch := make(chan [2]int)
+		var wg sync.WaitGroup
+		for i := 0; i < b.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for batch := range ch {
+					_ = run(batch[0], batch[1])
+				}
+			}()
+		}
+		for _, batch := range batches {
+			ch <- batch
+		}
+		close(ch)
+		wg.Wait()
+	}
+	if bulkErr != nil {
+		if bulkErr.Err == mongo.ErrEmptySlice {
+			return nil, bulkErr.Err
+		}
+		sort.Slice(bulkErr.WriteErrors, func(i, j int) bool {
+			return bulkErr.WriteErrors[i].Index < bulkErr.WriteErrors[j].Index
+		})
 		// In original mgo, queue is not reset in case of error.
-		return nil, err
+		return res, bulkErr
 	}
 
 	// Empty the queue for possible reuse, as per mgo's behavior.
+	hooks := b.hooks
 	b.queue = nil
+	b.hooks = nil
+
+	for _, h := range hooks {
+		if h.skip {
+			continue
+		}
+		if err := b.coll.afterHook(hook.WithOperation(ctx, h.op), h.doc, h.after, h.hook); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// batches returns the [start, end) of every batch of queue
+func (b *Bulk) batches() [][2]int {
+	size := b.batchSize
+	if size <= 0 || size > len(b.queue) {
+		size = len(b.queue)
+	}
+	if size == 0 {
+		// let driver report the empty bulk
+		return [][2]int{{0, 0}}
+	}
+	var batches [][2]int
+	for start := 0; start < len(b.queue); start += size {
+		end := start + size
+		if end > len(b.queue) {
+			end = len(b.queue)
+		}
+		batches = append(batches, [2]int{start, end})
+	}
+	return batches
+}
+
+// runBatch executes models in a single bulk write
+func (b *Bulk) runBatch(ctx context.Context, models []mongo.WriteModel, opts *options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
+	err = intercept(ctx, b.coll.collection, interceptor.BulkWrite, nil, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		result, err = b.coll.collection.BulkWrite(ctx, models, opts)
+		if result != nil {
+			op.Inserted = result.InsertedCount
+			op.Matched = result.MatchedCount
+			op.Modified = result.ModifiedCount
+			op.Upserted = result.UpsertedCount
+			op.Deleted = result.DeletedCount
+		}
+		return
+	})
+	return
+}
 
-	return &BulkResult{
-		InsertedCount: result.InsertedCount,
-		MatchedCount:  result.MatchedCount,
-		ModifiedCount: result.ModifiedCount,
-		DeletedCount:  result.DeletedCount,
-		UpsertedCount: result.UpsertedCount,
-		UpsertedIDs:   result.UpsertedIDs,
-	}, nil
+// bulkWriteErrors returns the write errors in err, offset is the index in queue of the first operation of batch
+func bulkWriteErrors(err error, queue []mongo.WriteModel, offset int) []BulkWriteError {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) {
+		return nil
+	}
+	writeErrors := make([]BulkWriteError, 0, len(bwe.WriteErrors))
+	for _, we := range bwe.WriteErrors {
+		writeErrors = append(writeErrors, BulkWriteError{
+			Index:     offset + we.Index,
+			Model:     queue[offset+we.Index],
+			Code:      we.Code,
+			Message:   we.Message,
+			Duplicate: mongo.IsDuplicateKeyError(we.WriteError),
+		})
+	}
+	return writeErrors
+}
+
+// merge adds the counts of result into r, offset is the index in queue of the first operation of result
+func (r *BulkResult) merge(result *mongo.BulkWriteResult, offset int64) {
+	if result == nil {
+		return
+	}
+	r.InsertedCount += result.InsertedCount
+	r.MatchedCount += result.MatchedCount
+	r.ModifiedCount += result.ModifiedCount
+	r.DeletedCount += result.DeletedCount
+	r.UpsertedCount += result.UpsertedCount
+	for i, id := range result.UpsertedIDs {
+		r.UpsertedIDs[offset+i] = id
+	}
 }
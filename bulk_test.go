@@ -2,13 +2,18 @@ package qmgo
 
 import (
 	"context"
+	"errors"
 
 	"testing"
 
+	"github.com/qiniu/qmgo/hook"
 	"github.com/qiniu/qmgo/operator"
+	opts "github.com/qiniu/qmgo/options"
+	"github.com/qiniu/qmgo/update"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestBulk(t *testing.T) {
@@ -61,3 +66,222 @@ func TestBulkUpsertOne(t *testing.T) {
 	ast.Equal(1, len(result.UpsertedIDs))
 	ast.Equal(int64(1), result.MatchedCount)
 }
+
+func TestBulkUpdateBuilder(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+
+	id := primitive.NewObjectID()
+	_, err := cli.InsertOne(context.Background(), UserInfo{Id: id, Name: "Lucas", Age: 12})
+	ast.NoError(err)
+
+	result, err := cli.Bulk().
+		UpdateOne(bson.M{"name": "Lucas"}, update.Set("weight", 40).Inc("age", 1)).
+		UpdateId(id, update.Inc("age", 1)).
+		Run(context.Background())
+	ast.NoError(err)
+	ast.Equal(int64(2), result.ModifiedCount)
+
+	ast.NoError(cli.UpdateId(context.Background(), id, update.Inc("age", 1)))
+	ast.Equal(update.ErrReplacementContainUpdateOperators,
+		cli.UpdateOne(context.Background(), bson.M{"_id": id}, update.From(bson.M{"$set": bson.M{"age": 1}, "name": "x"})))
+
+	res := UserInfo{}
+	ast.NoError(cli.Find(context.Background(), bson.M{"_id": id}).One(&res))
+	ast.Equal(uint16(15), res.Age)
+	ast.Equal(uint32(40), res.Weight)
+}
+
+func TestBulkMiddleware(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	// default fields and custom fields
+	u := &UserField{Name: "Lucas"}
+	skipped := &UserField{Name: "Alice"}
+	upsert := &UserHook{Name: "Jess"}
+	uh := &MyUpdateHook{}
+	_, err := cli.Bulk().
+		InsertOne(u).
+		InsertOne(skipped, opts.BulkOperationOptions{SkipMiddleware: true}).
+		Upsert(bson.M{"name": "Jess"}, upsert).
+		UpdateOne(bson.M{"name": "Lucas"}, bson.M{operator.Set: bson.M{"age": 7}}, opts.BulkOperationOptions{Hook: uh}).
+		Run(ctx)
+	ast.NoError(err)
+	ast.NotEqual(primitive.NilObjectID, u.Id)
+	ast.NotEmpty(u.MyId)
+	ast.False(u.CreateTimeAt.IsZero())
+	ast.Equal(primitive.NilObjectID, skipped.Id)
+	ast.Empty(skipped.MyId)
+	ast.Equal(1, upsert.beforeCount)
+	ast.Equal(1, upsert.afterCount)
+	ast.Equal(1, uh.beforeUpdateCount)
+	ast.Equal(1, uh.afterUpdateCount)
+
+	// validation fails, nothing is written
+	bulk := cli.Bulk().
+		InsertOne(&User{Age: 45, Email: "1234@gmail.com"}).
+		InsertOne(&User{Age: 200, Email: "1234@gmail.com"})
+	_, err = bulk.Run(ctx)
+	ast.Error(err)
+	n, err := cli.Find(ctx, bson.M{"age": 45}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(0), n)
+}
+
+type bulkCtxKey struct{}
+
+func TestBulkMiddlewareContext(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	var tenants, filters []interface{}
+	cli.Hooks().
+		BeforeInsert(func(ctx context.Context, doc interface{}) error {
+			tenants = append(tenants, ctx.Value(bulkCtxKey{}))
+			return nil
+		}).
+		BeforeUpdate(func(ctx context.Context, doc interface{}) error {
+			tenants = append(tenants, ctx.Value(bulkCtxKey{}))
+			filters = append(filters, hook.OperationFromContext(ctx).Filter)
+			return nil
+		})
+	bulk := cli.Bulk().
+		InsertOne(&UserInfo{Id: primitive.NewObjectID(), Name: "Lucas"}).
+		UpdateOne(bson.M{"name": "Lucas"}, bson.M{operator.Set: bson.M{"age": 7}}, opts.BulkOperationOptions{Hook: &MyUpdateHook{}})
+	// the middlewares are called by Run
+	ast.Empty(tenants)
+	_, err := bulk.Run(context.WithValue(ctx, bulkCtxKey{}, "t1"))
+	ast.NoError(err)
+	ast.Equal([]interface{}{"t1", "t1"}, tenants)
+	ast.Equal([]interface{}{bson.M{"name": "Lucas"}}, filters)
+
+	// the prepared operations are not prepared again
+	tenants = nil
+	fail := errors.New("fail")
+	cli.Hooks().BeforeRemove(func(ctx context.Context, doc interface{}) error {
+		return fail
+	})
+	bulk = cli.Bulk().
+		InsertOne(&UserInfo{Id: primitive.NewObjectID(), Name: "Alice"}).
+		Remove(bson.M{"name": "Alice"}, opts.BulkOperationOptions{Hook: &MyRemoveHook{}})
+	_, err = bulk.Run(ctx)
+	ast.Equal(fail, err)
+	ast.Len(tenants, 1)
+	_, err = bulk.Run(ctx)
+	ast.Equal(fail, err)
+	ast.Len(tenants, 1)
+}
+
+func TestBulk_batches(t *testing.T) {
+	ast := require.New(t)
+
+	b := &Bulk{queue: make([]mongo.WriteModel, 5)}
+	ast.Equal([][2]int{{0, 5}}, b.batches())
+	b.SetBatchSize(2)
+	ast.Equal([][2]int{{0, 2}, {2, 4}, {4, 5}}, b.batches())
+	b.SetBatchSize(10)
+	ast.Equal([][2]int{{0, 5}}, b.batches())
+	b.queue = nil
+	ast.Equal([][2]int{{0, 0}}, b.batches())
+}
+
+func TestBulkBatch(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	// ordered
+	var progress [][2]int
+	bulk := cli.Bulk().SetBatchSize(3).SetProgress(func(done, total int) {
+		progress = append(progress, [2]int{done, total})
+	})
+	for i := 0; i < 7; i++ {
+		bulk.InsertOne(bson.M{"name": "batch", "age": i})
+	}
+	bulk.UpsertOne(bson.M{"name": "upsert"}, bson.M{operator.Set: bson.M{"age": 1}})
+	result, err := bulk.Run(ctx)
+	ast.NoError(err)
+	ast.Equal(int64(7), result.InsertedCount)
+	ast.Equal(int64(1), result.UpsertedCount)
+	ast.Contains(result.UpsertedIDs, int64(7))
+	ast.Equal([][2]int{{3, 8}, {6, 8}, {8, 8}}, progress)
+
+	// unordered in parallel
+	progress = nil
+	bulk = cli.Bulk().SetOrdered(false).SetBatchSize(2).SetConcurrency(3).SetProgress(func(done, total int) {
+		progress = append(progress, [2]int{done, total})
+	})
+	for i := 0; i < 7; i++ {
+		bulk.UpdateAll(bson.M{"name": "batch", "age": i}, bson.M{operator.Set: bson.M{"age": i + 10}})
+	}
+	result, err = bulk.Run(ctx)
+	ast.NoError(err)
+	ast.Equal(int64(7), result.ModifiedCount)
+	ast.Len(progress, 4)
+	ast.Equal([2]int{7, 7}, progress[3])
+	n, err := cli.Find(ctx, bson.M{"age": bson.M{"$gte": 10}}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(7), n)
+}
+
+func TestBulkWriteErrors(t *testing.T) {
+	ast := require.New(t)
+
+	queue := []mongo.WriteModel{
+		mongo.NewInsertOneModel(), mongo.NewInsertOneModel(), mongo.NewDeleteOneModel(), mongo.NewInsertOneModel(),
+	}
+	err := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}},
+		{WriteError: mongo.WriteError{Index: 1, Code: 2, Message: "bad value"}},
+	}}
+	writeErrors := bulkWriteErrors(err, queue, 2)
+	ast.Equal([]BulkWriteError{
+		{Index: 2, Model: queue[2], Code: 11000, Message: "E11000 duplicate key error", Duplicate: true},
+		{Index: 3, Model: queue[3], Code: 2, Message: "bad value"},
+	}, writeErrors)
+	ast.Nil(bulkWriteErrors(errors.New("fail"), queue, 0))
+
+	bulkErr := &BulkError{WriteErrors: writeErrors, Err: err}
+	ast.EqualError(bulkErr, "bulk write failed with 2 write errors, the first at index 2: E11000 duplicate key error")
+	ast.True(errors.As(bulkErr, &mongo.BulkWriteException{}))
+	ast.EqualError(&BulkError{Err: errors.New("fail")}, "bulk write failed: fail")
+}
+
+func TestBulkError(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	id := primitive.NewObjectID()
+	_, err := cli.InsertOne(ctx, bson.M{"_id": id, "name": "Lucas"})
+	ast.NoError(err)
+
+	bulk := cli.Bulk().SetOrdered(false).SetBatchSize(2).
+		InsertOne(bson.M{"name": "Alice"}).
+		InsertOne(bson.M{"_id": id, "name": "Lucas"}).
+		InsertOne(bson.M{"name": "Jess"}).
+		InsertOne(bson.M{"_id": id, "name": "Lucas"})
+	result, err := bulk.Run(ctx)
+	var bulkErr *BulkError
+	ast.True(errors.As(err, &bulkErr))
+	ast.Equal(int64(2), result.InsertedCount)
+	ast.Len(bulkErr.WriteErrors, 2)
+	ast.Equal(1, bulkErr.WriteErrors[0].Index)
+	ast.Equal(3, bulkErr.WriteErrors[1].Index)
+	ast.True(bulkErr.WriteErrors[0].Duplicate)
+	ast.Equal(11000, bulkErr.WriteErrors[1].Code)
+	ast.True(IsDup(err))
+}
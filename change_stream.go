@@ -0,0 +1,361 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	opts "github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// OperationType is the type of operation that occurred in a change event
+// Reference: https://docs.mongodb.com/manual/reference/change-events/
+type OperationType string
+
+const (
+	OperationInsert       OperationType = "insert"
+	OperationUpdate       OperationType = "update"
+	OperationReplace      OperationType = "replace"
+	OperationDelete       OperationType = "delete"
+	OperationDrop         OperationType = "drop"
+	OperationRename       OperationType = "rename"
+	OperationDropDatabase OperationType = "dropDatabase"
+	OperationInvalidate   OperationType = "invalidate"
+)
+
+// UpdateDescription describes the fields updated or removed by an update operation
+type UpdateDescription struct {
+	UpdatedFields   bson.M   `bson:"updatedFields"`
+	RemovedFields   []string `bson:"removedFields"`
+	TruncatedArrays []bson.M `bson:"truncatedArrays"`
+}
+
+// Namespace is the database and collection affected by the change event
+type Namespace struct {
+	DB   string `bson:"db"`
+	Coll string `bson:"coll"`
+}
+
+// ChangeEvent is the change event whose full document is decoded into T
+// FullDocument is nil for delete events, and for update events unless FullDocument option is set to UpdateLookup
+type ChangeEvent[T any] struct {
+	ResumeToken       bson.Raw            `bson:"_id"`
+	OperationType     OperationType       `bson:"operationType"`
+	FullDocument      *T                  `bson:"fullDocument"`
+	DocumentKey       bson.M              `bson:"documentKey"`
+	UpdateDescription *UpdateDescription  `bson:"updateDescription"`
+	Ns                Namespace           `bson:"ns"`
+	To                *Namespace          `bson:"to"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+}
+
+// ResumeTokenStore persists the resume token of a ChangeStream, so the stream can continue where it stopped
+type ResumeTokenStore interface {
+	// Load returns the saved resume token, nil if there is none
+	Load(ctx context.Context) (bson.Raw, error)
+	// Save saves the resume token
+	Save(ctx context.Context, token bson.Raw) error
+}
+
+// MemoryResumeTokenStore keeps the resume token in memory, it is safe for concurrent use
+type MemoryResumeTokenStore struct {
+	mu    sync.Mutex
+	token bson.Raw
+}
+
+// NewMemoryResumeTokenStore creates an in-memory ResumeTokenStore
+func NewMemoryResumeTokenStore() *MemoryResumeTokenStore {
+	return &MemoryResumeTokenStore{}
+}
+
+// Load returns the saved resume token
+func (s *MemoryResumeTokenStore) Load(ctx context.Context) (bson.Raw, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, nil
+}
+
+// Save saves the resume token
+func (s *MemoryResumeTokenStore) Save(ctx context.Context, token bson.Raw) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = append(bson.Raw(nil), token...)
+	return nil
+}
+
+// CollectionResumeTokenStore saves the resume token into a collection, as document {_id: key, token: ..., updateAt: ...}
+type CollectionResumeTokenStore struct {
+	coll *Collection
+	key  string
+}
+
+// NewCollectionResumeTokenStore creates a ResumeTokenStore backed by coll, key identifies the change stream
+func NewCollectionResumeTokenStore(coll *Collection, key string) *CollectionResumeTokenStore {
+	return &CollectionResumeTokenStore{coll: coll, key: key}
+}
+
+// Load returns the saved resume token
+func (s *CollectionResumeTokenStore) Load(ctx context.Context) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := s.coll.collection.FindOne(ctx, bson.M{"_id": s.key}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.Token, nil
+}
+
+// Save saves the resume token
+func (s *CollectionResumeTokenStore) Save(ctx context.Context, token bson.Raw) error {
+	_, err := s.coll.collection.UpdateOne(ctx, bson.M{"_id": s.key},
+		bson.M{"$set": bson.M{"token": token, "updateAt": time.Now()}}, options.Update().SetUpsert(true))
+	return err
+}
+
+// ChangeStream wraps the change stream of driver, it decodes the change events into ChangeEvent[T], saves the
+// resume token into ResumeTokenStore and reopens the stream from the last resume token after transient errors
+// Create it by WatchStream, WatchDatabaseStream or WatchClientStream, use Watch for the raw change stream of driver
+//
+// ChangeStream is not safe for concurrent use.
+type ChangeStream[T any] struct {
+	open   func(ctx context.Context, opt *options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+	opt    *options.ChangeStreamOptions
+	stream *mongo.ChangeStream
+	store  ResumeTokenStore
+	token  bson.Raw
+	err    error
+	// invalidated is true if the last event is invalidate, whose token can only be used by StartAfter
+	invalidated bool
+
+	maxRetries    int
+	retryInterval time.Duration
+}
+
+const (
+	defaultChangeStreamMaxRetries    = 5
+	defaultChangeStreamRetryInterval = time.Second
+)
+
+// WatchStream returns a ChangeStream for all changes on coll, the full documents are decoded into T
+// The resume token is loaded from and saved into store, store can be nil if the token needn't be persisted
+// Example:
+//
+//	cs, err := qmgo.WatchStream[UserInfo](ctx, coll, mongo.Pipeline{}, qmgo.NewMemoryResumeTokenStore())
+//	defer cs.Close(ctx)
+//	var event qmgo.ChangeEvent[UserInfo]
+//	for cs.Next(ctx, &event) {
+//		...
+//	}
+//	err = cs.Err()
+func WatchStream[T any](ctx context.Context, coll *Collection, pipeline interface{}, store ResumeTokenStore,
+	opts ...*opts.ChangeStreamOptions) (*ChangeStream[T], error) {
+	return newChangeStream[T](ctx, store, opts, func(ctx context.Context, opt *options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+		return coll.collection.Watch(ctx, pipeline, opt)
+	})
+}
+
+// WatchDatabaseStream returns a ChangeStream for all changes to db, see WatchStream
+func WatchDatabaseStream[T any](ctx context.Context, db *Database, pipeline interface{}, store ResumeTokenStore,
+	opts ...*opts.ChangeStreamOptions) (*ChangeStream[T], error) {
+	return newChangeStream[T](ctx, store, opts, func(ctx context.Context, opt *options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+		return db.database.Watch(ctx, pipeline, opt)
+	})
+}
+
+// WatchClientStream returns a ChangeStream for all changes to the deployment of c, see WatchStream
+func WatchClientStream[T any](ctx context.Context, c *Client, pipeline interface{}, store ResumeTokenStore,
+	opts ...*opts.ChangeStreamOptions) (*ChangeStream[T], error) {
+	return newChangeStream[T](ctx, store, opts, func(ctx context.Context, opt *options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+		return c.client.Watch(ctx, pipeline, opt)
+	})
+}
+
+// newChangeStream creates ChangeStream, the stream is opened from the token in store if there is one
+func newChangeStream[T any](ctx context.Context, store ResumeTokenStore, o []*opts.ChangeStreamOptions,
+	open func(ctx context.Context, opt *options.ChangeStreamOptions) (*mongo.ChangeStream, error)) (*ChangeStream[T], error) {
+	opt := options.ChangeStream()
+	if len(o) > 0 && o[0].ChangeStreamOptions != nil {
+		opt = o[0].ChangeStreamOptions
+	}
+	cs := &ChangeStream[T]{
+		open:          open,
+		opt:           opt,
+		store:         store,
+		maxRetries:    defaultChangeStreamMaxRetries,
+		retryInterval: defaultChangeStreamRetryInterval,
+	}
+	if store != nil {
+		token, err := store.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cs.token = token
+	}
+	if err := cs.reopen(ctx); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// SetRetry sets how many times in a row the stream is reopened after transient errors, and the interval between
+// Default is 5 times with 1 second interval, maxRetries 0 means no retry
+func (cs *ChangeStream[T]) SetRetry(maxRetries int, interval time.Duration) *ChangeStream[T] {
+	cs.maxRetries = maxRetries
+	cs.retryInterval = interval
+	return cs
+}
+
+// Next gets the next change event and decodes it into event
+// It blocks until an event is available, ctx is done or an error occurs
+// Transient errors are retried by reopening the stream from the last resume token
+// It returns false if an error occurs or the stream is closed, check Err for the reason.
+// The stream is closed after an invalidate event, like the collection is dropped, calling Next again
+// reopens the stream after the invalidate event.
+func (cs *ChangeStream[T]) Next(ctx context.Context, event *ChangeEvent[T]) bool {
+	if cs.err != nil {
+		return false
+	}
+	for retries := 0; ; retries++ {
+		var err error
+		if cs.stream == nil {
+			err = cs.reopen(ctx)
+		}
+		if err == nil {
+			if cs.stream.Next(ctx) {
+				return cs.decode(ctx, event)
+			}
+			if err = cs.stream.Err(); err == nil {
+				// the stream is closed, like after an invalidate event
+				_ = cs.stream.Close(ctx)
+				cs.stream = nil
+				return false
+			}
+		}
+		if ctx.Err() != nil || !isResumableError(err) || retries >= cs.maxRetries {
+			cs.err = err
+			return false
+		}
+		if cs.stream != nil {
+			_ = cs.stream.Close(ctx)
+			cs.stream = nil
+		}
+		select {
+		case <-ctx.Done():
+			cs.err = ctx.Err()
+			return false
+		case <-time.After(cs.retryInterval):
+		}
+	}
+}
+
+// decode decodes the current event and saves its resume token
+func (cs *ChangeStream[T]) decode(ctx context.Context, event *ChangeEvent[T]) bool {
+	if err := cs.stream.Decode(event); err != nil {
+		cs.err = err
+		return false
+	}
+	cs.token = cs.stream.ResumeToken()
+	cs.invalidated = event.OperationType == OperationInvalidate
+	if cs.store != nil {
+		if err := cs.store.Save(ctx, cs.token); err != nil {
+			cs.err = err
+			return false
+		}
+	}
+	return true
+}
+
+// reopen opens the stream, from the last resume token if there is one
+// The token of invalidate event is used by StartAfter, as the server rejects it in ResumeAfter. The token loaded
+// from store may be the one of invalidate event too, so the stream is opened by StartAfter if ResumeAfter is rejected.
+func (cs *ChangeStream[T]) reopen(ctx context.Context) error {
+	stream, err := cs.open(ctx, cs.resumeOptions())
+	if err != nil && cs.token != nil && !cs.invalidated && isInvalidateResumeError(err) {
+		cs.invalidated = true
+		stream, err = cs.open(ctx, cs.resumeOptions())
+	}
+	if err != nil {
+		return err
+	}
+	cs.stream = stream
+	return nil
+}
+
+// resumeOptions returns the options to open the stream from the last resume token
+func (cs *ChangeStream[T]) resumeOptions() *options.ChangeStreamOptions {
+	if cs.token == nil {
+		return cs.opt
+	}
+	opt := options.MergeChangeStreamOptions(cs.opt)
+	opt.ResumeAfter, opt.StartAfter, opt.StartAtOperationTime = nil, nil, nil
+	if cs.invalidated {
+		opt.SetStartAfter(cs.token)
+	} else {
+		opt.SetResumeAfter(cs.token)
+	}
+	return opt
+}
+
+// ResumeToken returns the resume token of the last event returned by Next
+func (cs *ChangeStream[T]) ResumeToken() bson.Raw {
+	return cs.token
+}
+
+// Err returns the last error of ChangeStream, if no error occurs, return nil
+func (cs *ChangeStream[T]) Err() error {
+	return cs.err
+}
+
+// Close closes this change stream
+func (cs *ChangeStream[T]) Close(ctx context.Context) error {
+	if cs.stream == nil {
+		return nil
+	}
+	return cs.stream.Close(ctx)
+}
+
+// isInvalidateResumeError checks if err is returned because the token of invalidate event is used by ResumeAfter
+func isInvalidateResumeError(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && strings.Contains(err.Error(), "invalidate")
+}
+
+// isResumableError checks if the change stream can be reopened after err
+func isResumableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) && se.HasErrorLabel("ResumableChangeStreamError") {
+		return true
+	}
+	var sse topology.ServerSelectionError
+	return errors.As(err, &sse)
+}
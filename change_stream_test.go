@@ -0,0 +1,186 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/qiniu/qmgo/options"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMemoryResumeTokenStore(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+
+	s := NewMemoryResumeTokenStore()
+	token, err := s.Load(ctx)
+	ast.NoError(err)
+	ast.Nil(token)
+
+	raw, err := bson.Marshal(bson.M{"_data": "abc"})
+	ast.NoError(err)
+	ast.NoError(s.Save(ctx, raw))
+	token, err = s.Load(ctx)
+	ast.NoError(err)
+	ast.Equal(bson.Raw(raw), token)
+
+	// the saved token is a copy
+	raw[len(raw)-2] = 'x'
+	token, err = s.Load(ctx)
+	ast.NoError(err)
+	ast.NotEqual(bson.Raw(raw), token)
+}
+
+func TestIsResumableError(t *testing.T) {
+	ast := require.New(t)
+
+	ast.False(isResumableError(nil))
+	ast.False(isResumableError(context.Canceled))
+	ast.False(isResumableError(fmt.Errorf("wrap: %w", context.DeadlineExceeded)))
+	ast.False(isResumableError(errors.New("decode error")))
+	ast.False(isResumableError(mongo.CommandError{Code: 2, Name: "BadValue"}))
+	ast.True(isResumableError(mongo.CommandError{Code: 43, Labels: []string{"ResumableChangeStreamError"}}))
+	ast.True(isResumableError(mongo.CommandError{Labels: []string{"NetworkError"}}))
+}
+
+func TestChangeStream_reopen(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+	token, err := bson.Marshal(bson.M{"_data": "abc"})
+	ast.NoError(err)
+
+	var opened []*officialOpts.ChangeStreamOptions
+	var errs []error
+	cs := &ChangeStream[UserInfo]{
+		opt: officialOpts.ChangeStream().SetStartAtOperationTime(&primitive.Timestamp{T: 1}),
+		open: func(ctx context.Context, opt *officialOpts.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+			opened = append(opened, opt)
+			err := errors.New("closed")
+			if len(errs) > 0 {
+				err, errs = errs[0], errs[1:]
+			}
+			return nil, err
+		},
+	}
+
+	// the options as they are without token
+	ast.Error(cs.reopen(ctx))
+	ast.Same(cs.opt, opened[0])
+
+	// resume after the last event
+	cs.token = token
+	ast.Error(cs.reopen(ctx))
+	ast.Equal(bson.Raw(token), opened[1].ResumeAfter)
+	ast.Nil(opened[1].StartAfter)
+	ast.Nil(opened[1].StartAtOperationTime)
+
+	// start after the invalidate event
+	cs.invalidated = true
+	ast.Error(cs.reopen(ctx))
+	ast.Nil(opened[2].ResumeAfter)
+	ast.Equal(bson.Raw(token), opened[2].StartAfter)
+
+	// the token of invalidate event loaded from store is rejected by ResumeAfter
+	cs.invalidated = false
+	opened = nil
+	errs = []error{mongo.CommandError{Code: 260, Message: "cannot resume from an invalidate notification"}}
+	ast.Error(cs.reopen(ctx))
+	ast.Len(opened, 2)
+	ast.Equal(bson.Raw(token), opened[0].ResumeAfter)
+	ast.Equal(bson.Raw(token), opened[1].StartAfter)
+	ast.True(cs.invalidated)
+}
+
+func TestCollection_WatchStream(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+	ctx := context.Background()
+
+	tokenColl := cli.Database.Collection("resume_tokens")
+	defer tokenColl.DropCollection(ctx)
+	store := NewCollectionResumeTokenStore(tokenColl, "test")
+
+	opts := &options.ChangeStreamOptions{officialOpts.ChangeStream().SetFullDocument(officialOpts.UpdateLookup)}
+	cs, err := WatchStream[UserInfo](ctx, cli.Collection, mongo.Pipeline{}, store, opts)
+	ast.NoError(err)
+
+	ui := UserInfo{Id: primitive.NewObjectID(), Name: "Lucas", Age: 17}
+	_, err = cli.InsertOne(ctx, &ui)
+	ast.NoError(err)
+	ast.NoError(cli.UpdateOne(ctx, bson.M{"name": "Lucas"}, bson.M{"$set": bson.M{"age": 18}}))
+
+	var event ChangeEvent[UserInfo]
+	ast.True(cs.Next(ctx, &event))
+	ast.Equal(OperationInsert, event.OperationType)
+	ast.Equal("Lucas", event.FullDocument.Name)
+	ast.Equal(uint16(17), event.FullDocument.Age)
+	ast.Equal(cli.GetCollectionName(), event.Ns.Coll)
+	ast.NoError(cs.Close(ctx))
+
+	// token is saved in store
+	token, err := store.Load(ctx)
+	ast.NoError(err)
+	ast.Equal(event.ResumeToken, token)
+	ast.Equal(token, cs.ResumeToken())
+
+	// a new stream resumes after the saved token
+	cs, err = As[UserInfo](cli.Collection).WatchStream(ctx, mongo.Pipeline{}, store, opts)
+	ast.NoError(err)
+	defer cs.Close(ctx)
+	nextCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	event = ChangeEvent[UserInfo]{}
+	ast.True(cs.Next(nextCtx, &event))
+	ast.Equal(OperationUpdate, event.OperationType)
+	ast.Equal(uint16(18), event.FullDocument.Age)
+	ast.Equal(int32(18), event.UpdateDescription.UpdatedFields["age"])
+	ast.NoError(cs.Err())
+}
+
+func TestDatabase_WatchStream(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+	ctx := context.Background()
+
+	cs, err := WatchDatabaseStream[UserInfo](ctx, cli.Database, mongo.Pipeline{}, nil)
+	ast.NoError(err)
+	defer cs.Close(ctx)
+
+	_, err = cli.InsertOne(ctx, UserInfo{Id: primitive.NewObjectID(), Name: "Lucas", Age: 17})
+	ast.NoError(err)
+
+	var event ChangeEvent[UserInfo]
+	ast.True(cs.Next(ctx, &event))
+	ast.Equal(OperationInsert, event.OperationType)
+	ast.Equal(cli.GetDatabaseName(), event.Ns.DB)
+
+	// stream stops when ctx is done
+	nextCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	ast.False(cs.Next(nextCtx, &event))
+	ast.Error(cs.Err())
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/qiniu/qmgo/options"
@@ -125,12 +126,20 @@ func Open(ctx context.Context, conf *Config, o ...options.ClientOptions) (cli *Q
 	return
 }
 
+// Watch returns a change stream for all changes on the collection of QmgoClient
+// Use qc.Database.Watch or qc.Client.Watch to watch the database or the deployment
+func (qc *QmgoClient) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	return qc.Collection.Watch(ctx, pipeline, opts...)
+}
+
 // Client creates client to mongo
 type Client struct {
 	client *mongo.Client
 	conf   Config
 
 	registry *bsoncodec.Registry
+	// hooks are the hook functions of collections keyed by the database and collection name
+	hooks *sync.Map
 }
 
 // NewClient creates Qmgo MongoDB client
@@ -148,6 +157,7 @@ func NewClient(ctx context.Context, conf *Config, o ...options.ClientOptions) (c
 		client:   client,
 		conf:     *conf,
 		registry: opt.Registry,
+		hooks:    &sync.Map{},
 	}
 	return
 }
@@ -293,7 +303,7 @@ func (c *Client) Database(name string, options ...*options.DatabaseOptions) *Dat
 		opts = append(opts, o.DatabaseOptions)
 	}
 	databaseOpts := officialOpts.MergeDatabaseOptions(opts...)
-	return &Database{database: c.client.Database(name, databaseOpts), registry: c.registry}
+	return &Database{database: c.client.Database(name, databaseOpts), registry: c.registry, hooks: c.hooks}
 }
 
 // Session create one session on client
@@ -318,6 +328,8 @@ func (c *Client) Session(opt ...*options.SessionOptions) (*Session, error) {
 //     the whole transaction will retry, so this transaction must be idempotent
 //   - if operations in callback return qmgo.ErrTransactionNotSupported,
 //   - If the ctx parameter already has a Session attached to it, it will be replaced by this session.
+//   - side effects in callback, like publishing events, should be registered by OnCommit or OnAbort,
+//     which are called once after the transaction is finally committed or aborted
 func (c *Client) DoTransaction(ctx context.Context, callback func(sessCtx context.Context) (interface{}, error), opts ...*options.TransactionOptions) (interface{}, error) {
 	if !c.transactionAllowed() {
 		return nil, ErrTransactionNotSupported
@@ -330,6 +342,16 @@ func (c *Client) DoTransaction(ctx context.Context, callback func(sessCtx contex
 	return s.StartTransaction(ctx, callback, opts...)
 }
 
+// Watch returns a change stream for all changes to the deployment. See
+// https://docs.mongodb.com/manual/changeStreams/ for more information about change streams.
+func (c *Client) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	changeStreamOption := officialOpts.ChangeStream()
+	if len(opts) > 0 && opts[0].ChangeStreamOptions != nil {
+		changeStreamOption = opts[0].ChangeStreamOptions
+	}
+	return c.client.Watch(ctx, pipeline, changeStreamOption)
+}
+
 // ServerVersion get the version of mongoDB server, like 4.4.0
 func (c *Client) ServerVersion() string {
 	var buildInfo bson.Raw
@@ -19,9 +19,12 @@ import (
 	"reflect"
 	"strings"
 
-	"github.com/qiniu/qmgo/middleware"
+	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/hook"
+	"github.com/qiniu/qmgo/interceptor"
 	"github.com/qiniu/qmgo/operator"
 	opts "github.com/qiniu/qmgo/options"
+	"github.com/qiniu/qmgo/validator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -31,19 +34,28 @@ import (
 // Collection is a handle to a MongoDB collection
 type Collection struct {
 	collection *mongo.Collection
+	session    mongo.Session
+	hooks      *hook.Hooks
+	model      interface{}
+	// softDelete is the bson key of the deletion time if soft delete is enabled
+	softDelete string
 
 	registry *bsoncodec.Registry
 }
 
 // Find find by condition filter，return QueryI
+// If soft delete is enabled, the soft deleted documents are excluded unless QueryI.WithDeleted is called
 func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...opts.FindOptions) QueryI {
-
+	ctx = c.bindSession(ctx)
 	return &Query{
 		ctx:        ctx,
 		collection: c.collection,
 		filter:     filter,
 		opts:       opts,
 		registry:   c.registry,
+		hooks:      c.hooks,
+		model:      c.model,
+		softDelete: c.softDelete,
 	}
 }
 
@@ -51,6 +63,7 @@ func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...opts.
 // If InsertHook in opts is set, hook works on it, otherwise hook try the doc as hook
 // Reference: https://docs.mongodb.com/manual/reference/command/insert/
 func (c *Collection) InsertOne(ctx context.Context, doc interface{}, opts ...opts.InsertOneOptions) (result *InsertOneResult, err error) {
+	ctx = c.bindSession(ctx)
 	h := doc
 	insertOneOpts := options.InsertOne()
 	if len(opts) > 0 {
@@ -61,17 +74,24 @@ func (c *Collection) InsertOne(ctx context.Context, doc interface{}, opts ...opt
 			h = opts[0].InsertHook
 		}
 	}
-	if err = middleware.Do(ctx, doc, operator.BeforeInsert, h); err != nil {
+	if err = c.beforeHook(ctx, doc, operator.BeforeInsert, h); err != nil {
 		return
 	}
-	res, err := c.collection.InsertOne(ctx, doc, insertOneOpts)
+	var res *mongo.InsertOneResult
+	err = intercept(ctx, c.collection, interceptor.InsertOne, nil, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.InsertOne(ctx, doc, insertOneOpts)
+		if res != nil {
+			op.Inserted = 1
+		}
+		return
+	})
 	if res != nil {
 		result = &InsertOneResult{InsertedID: res.InsertedID}
 	}
 	if err != nil {
 		return
 	}
-	if err = middleware.Do(ctx, doc, operator.AfterInsert, h); err != nil {
+	if err = c.afterHook(ctx, doc, operator.AfterInsert, h); err != nil {
 		return
 	}
 	return
@@ -81,6 +101,7 @@ func (c *Collection) InsertOne(ctx context.Context, doc interface{}, opts ...opt
 // If InsertHook in opts is set, hook works on it, otherwise hook try the doc as hook
 // Reference: https://docs.mongodb.com/manual/reference/command/insert/
 func (c *Collection) InsertMany(ctx context.Context, docs interface{}, opts ...opts.InsertManyOptions) (result *InsertManyResult, err error) {
+	ctx = c.bindSession(ctx)
 	h := docs
 	insertManyOpts := options.InsertMany()
 	if len(opts) > 0 {
@@ -91,7 +112,7 @@ func (c *Collection) InsertMany(ctx context.Context, docs interface{}, opts ...o
 			h = opts[0].InsertHook
 		}
 	}
-	if err = middleware.Do(ctx, docs, operator.BeforeInsert, h); err != nil {
+	if err = c.beforeHook(ctx, docs, operator.BeforeInsert, h); err != nil {
 		return
 	}
 	sDocs := interfaceToSliceInterface(docs)
@@ -99,14 +120,21 @@ func (c *Collection) InsertMany(ctx context.Context, docs interface{}, opts ...o
 		return nil, ErrNotValidSliceToInsert
 	}
 
-	res, err := c.collection.InsertMany(ctx, sDocs, insertManyOpts)
+	var res *mongo.InsertManyResult
+	err = intercept(ctx, c.collection, interceptor.InsertMany, nil, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.InsertMany(ctx, sDocs, insertManyOpts)
+		if res != nil {
+			op.Inserted = int64(len(res.InsertedIDs))
+		}
+		return
+	})
 	if res != nil {
 		result = &InsertManyResult{InsertedIDs: res.InsertedIDs}
 	}
 	if err != nil {
 		return
 	}
-	if err = middleware.Do(ctx, docs, operator.AfterInsert, h); err != nil {
+	if err = c.afterHook(ctx, docs, operator.AfterInsert, h); err != nil {
 		return
 	}
 	return
@@ -135,6 +163,7 @@ func interfaceToSliceInterface(docs interface{}) []interface{} {
 // If replacement has "_id" field and the document is existed, please initial it with existing id(even with Qmgo default field feature).
 // Otherwise, "the (immutable) field '_id' altered" error happens.
 func (c *Collection) Upsert(ctx context.Context, filter interface{}, replacement interface{}, opts ...opts.UpsertOptions) (result *UpdateResult, err error) {
+	ctx = c.bindSession(ctx)
 	h := replacement
 	officialOpts := options.Replace().SetUpsert(true)
 
@@ -147,11 +176,16 @@ func (c *Collection) Upsert(ctx context.Context, filter interface{}, replacement
 			h = opts[0].UpsertHook
 		}
 	}
-	if err = middleware.Do(ctx, replacement, operator.BeforeUpsert, h); err != nil {
+	if err = c.beforeHook(ctx, replacement, operator.BeforeUpsert, h); err != nil {
 		return
 	}
 
-	res, err := c.collection.ReplaceOne(ctx, filter, replacement, officialOpts)
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.ReplaceOne, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.ReplaceOne(ctx, filter, replacement, officialOpts)
+		setUpdateCounts(op, res)
+		return
+	})
 
 	if res != nil {
 		result = translateUpdateResult(res)
@@ -159,7 +193,7 @@ func (c *Collection) Upsert(ctx context.Context, filter interface{}, replacement
 	if err != nil {
 		return
 	}
-	if err = middleware.Do(ctx, replacement, operator.AfterUpsert, h); err != nil {
+	if err = c.afterHook(ctx, replacement, operator.AfterUpsert, h); err != nil {
 		return
 	}
 	return
@@ -170,6 +204,7 @@ func (c *Collection) Upsert(ctx context.Context, filter interface{}, replacement
 // and cannot contain any update operators
 // Reference: https://docs.mongodb.com/manual/reference/operator/update/
 func (c *Collection) UpsertId(ctx context.Context, id interface{}, replacement interface{}, opts ...opts.UpsertOptions) (result *UpdateResult, err error) {
+	ctx = c.bindSession(ctx)
 	h := replacement
 	officialOpts := options.Replace().SetUpsert(true)
 
@@ -182,83 +217,284 @@ func (c *Collection) UpsertId(ctx context.Context, id interface{}, replacement i
 			h = opts[0].UpsertHook
 		}
 	}
-	if err = middleware.Do(ctx, replacement, operator.BeforeUpsert, h); err != nil {
+	if err = c.beforeHook(ctx, replacement, operator.BeforeUpsert, h); err != nil {
 		return
 	}
-	res, err := c.collection.ReplaceOne(ctx, bson.M{"_id": id}, replacement, officialOpts)
+	filter := bson.M{"_id": id}
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.ReplaceOne, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.ReplaceOne(ctx, filter, replacement, officialOpts)
+		setUpdateCounts(op, res)
+		return
+	})
 	if res != nil {
 		result = translateUpdateResult(res)
 	}
 	if err != nil {
 		return
 	}
-	if err = middleware.Do(ctx, replacement, operator.AfterUpsert, h); err != nil {
+	if err = c.afterHook(ctx, replacement, operator.AfterUpsert, h); err != nil {
 		return
 	}
 	return
 }
 
+// UpsertManyBy upserts every document of docs by the keyFields, that is the bson keys used as filter.
+// The fields of document are updated by $set, except id and createAt of DefaultField and CustomFields,
+// which are set by $setOnInsert, so they keep the values of existing documents.
+// The docs must be a slice, the hooks and default fields work on its elements in place, and the operations
+// are sent by Bulk. If error is returned, the documents may be partially upserted, see BulkError.
+func (c *Collection) UpsertManyBy(ctx context.Context, keyFields []string, docs interface{}) (result *UpsertManyResult, err error) {
+	if len(keyFields) == 0 {
+		return nil, ErrUpsertKeyMissing
+	}
+	sDocs := sliceElemRefs(docs)
+	if len(sDocs) == 0 {
+		return nil, ErrNotValidSliceToInsert
+	}
+	registry := c.registry
+	if registry == nil {
+		registry = bson.DefaultRegistry
+	}
+
+	ctx = c.bindSession(ctx)
+	bulk := c.Bulk()
+	for _, doc := range sDocs {
+		if err = c.beforeHook(ctx, doc, operator.BeforeUpsert); err != nil {
+			return
+		}
+		filter, update, err := upsertByModel(registry, keyFields, doc)
+		if err != nil {
+			return nil, err
+		}
+		bulk.UpsertOne(filter, update, opts.BulkOperationOptions{SkipMiddleware: true})
+	}
+	res, err := bulk.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &UpsertManyResult{InsertedIDs: make(map[int]interface{}), ModifiedCount: res.ModifiedCount}
+	for i := range sDocs {
+		if id, ok := res.UpsertedIDs[int64(i)]; ok {
+			result.InsertedIDs[i] = id
+		} else {
+			result.Updated = append(result.Updated, i)
+		}
+	}
+	for _, doc := range sDocs {
+		if err = c.afterHook(ctx, doc, operator.AfterUpsert); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// upsertByModel returns the filter by keyFields and the update of doc used by UpsertManyBy
+func upsertByModel(registry *bsoncodec.Registry, keyFields []string, doc interface{}) (filter, update bson.D, err error) {
+	raw, err := bson.MarshalWithRegistry(registry, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, key := range keyFields {
+		v, err := bson.Raw(raw).LookupErr(key)
+		if err != nil {
+			return nil, nil, ErrUpsertKeyMissing
+		}
+		filter = append(filter, bson.E{Key: key, Value: v})
+	}
+
+	insertOnly := make(map[string]bool)
+	for _, key := range field.InsertOnlyFields(doc) {
+		insertOnly[key] = true
+	}
+	var set, setOnInsert bson.D
+	if err = splitInsertOnly(bson.Raw(raw), "", insertOnly, &set, &setOnInsert); err != nil {
+		return nil, nil, err
+	}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: operator.Set, Value: set})
+	}
+	if len(setOnInsert) > 0 {
+		update = append(update, bson.E{Key: operator.SetOnInsert, Value: setOnInsert})
+	}
+	return filter, update, nil
+}
+
+// splitInsertOnly appends the fields of doc under prefix to setOnInsert if they are in insertOnly, and to set
+// otherwise. The subdocuments containing insert-only fields, like meta of "meta.createdAt", are flattened into
+// dotted keys, so the fields in them are set separately
+func splitInsertOnly(doc bson.Raw, prefix string, insertOnly map[string]bool, set, setOnInsert *bson.D) error {
+	elems, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, e := range elems {
+		key := prefix + e.Key()
+		sub, isDoc := e.Value().DocumentOK()
+		switch {
+		case insertOnly[key]:
+			*setOnInsert = append(*setOnInsert, bson.E{Key: key, Value: e.Value()})
+		case isDoc && hasPathPrefix(insertOnly, key):
+			if err = splitInsertOnly(sub, key+".", insertOnly, set, setOnInsert); err != nil {
+				return err
+			}
+		default:
+			*set = append(*set, bson.E{Key: key, Value: e.Value()})
+		}
+	}
+	return nil
+}
+
+// hasPathPrefix reports whether one of paths is under key, like "meta.createdAt" under "meta"
+func hasPathPrefix(paths map[string]bool, key string) bool {
+	for p := range paths {
+		if strings.HasPrefix(p, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// sliceElemRefs returns the elements of slice docs, the pointers of struct elements are used,
+// so hooks and fields change docs in place
+func sliceElemRefs(docs interface{}) []interface{} {
+	v := reflect.ValueOf(docs)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+	refs := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		e := v.Index(i)
+		if e.Kind() == reflect.Struct {
+			e = e.Addr()
+		}
+		refs = append(refs, e.Interface())
+	}
+	return refs
+}
+
 // UpdateOne executes an update command to update at most one document in the collection.
+// If UpdateHook in opts has the custom Version field, the document is updated only if its version is not changed,
+// and the version is increased, otherwise ErrVersionConflict is returned. The check is skipped for upsert.
 // Reference: https://docs.mongodb.com/manual/reference/operator/update/
 func (c *Collection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...opts.UpdateOptions) (err error) {
+	ctx = c.bindSession(ctx)
 	updateOpts := options.Update()
 
+	var h interface{}
 	if len(opts) > 0 {
 		if opts[0].UpdateOptions != nil {
 			updateOpts = opts[0].UpdateOptions
 		}
-		if opts[0].UpdateHook != nil {
-			if err = middleware.Do(ctx, opts[0].UpdateHook, operator.BeforeUpdate); err != nil {
-				return
-			}
+		h = opts[0].UpdateHook
+	}
+	ctx, hookOp := c.withOperation(ctx, filter, update, updateOpts)
+	if err = c.beforeHook(ctx, h, operator.BeforeUpdate); err != nil {
+		return
+	}
+	if err = validator.Update(c.model, update); err != nil {
+		return
+	}
+	upsert := updateOpts.Upsert != nil && *updateOpts.Upsert
+	if update, err = withUpdateFields(c.registry, c.model, filter, update, upsert); err != nil {
+		return
+	}
+	query := filter
+	key, version, versioned := field.VersionOf(h)
+	if versioned = versioned && !upsert; versioned {
+		query = andFilter(filter, versionFilter(key, version))
+		if update, err = incVersion(update, key); err != nil {
+			return
 		}
 	}
 
-	res, err := c.collection.UpdateOne(ctx, filter, update, updateOpts)
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.UpdateOne, query, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.UpdateOne(ctx, query, update, updateOpts)
+		setUpdateCounts(op, res)
+		return
+	})
 	if res != nil && res.MatchedCount == 0 {
 		// UpdateOne support upsert function
-		if updateOpts.Upsert == nil || !*updateOpts.Upsert {
+		if versioned {
+			err = c.versionConflict(ctx, filter)
+		} else if !upsert {
 			err = ErrNoSuchDocuments
 		}
 	}
 	if err != nil {
 		return err
 	}
-	if len(opts) > 0 && opts[0].UpdateHook != nil {
-		if err = middleware.Do(ctx, opts[0].UpdateHook, operator.AfterUpdate); err != nil {
-			return
-		}
+	if versioned {
+		field.UpdateVersion(h, version+1)
+	}
+	hookOp.UpdateResult = res
+	if err = c.afterHook(ctx, h, operator.AfterUpdate); err != nil {
+		return
 	}
 	return err
 }
 
 // UpdateId executes an update command to update at most one document in the collection.
+// The version of UpdateHook in opts is checked like UpdateOne, and the check is skipped for upsert too
 // Reference: https://docs.mongodb.com/manual/reference/operator/update/
 func (c *Collection) UpdateId(ctx context.Context, id interface{}, update interface{}, opts ...opts.UpdateOptions) (err error) {
+	ctx = c.bindSession(ctx)
 	updateOpts := options.Update()
 
+	var h interface{}
 	if len(opts) > 0 {
 		if opts[0].UpdateOptions != nil {
 			updateOpts = opts[0].UpdateOptions
 		}
-		if opts[0].UpdateHook != nil {
-			if err = middleware.Do(ctx, opts[0].UpdateHook, operator.BeforeUpdate); err != nil {
-				return
-			}
+		h = opts[0].UpdateHook
+	}
+	filter := bson.M{"_id": id}
+	ctx, hookOp := c.withOperation(ctx, filter, update, updateOpts)
+	if err = c.beforeHook(ctx, h, operator.BeforeUpdate); err != nil {
+		return
+	}
+	if err = validator.Update(c.model, update); err != nil {
+		return
+	}
+	upsert := updateOpts.Upsert != nil && *updateOpts.Upsert
+	if update, err = withUpdateFields(c.registry, c.model, filter, update, upsert); err != nil {
+		return
+	}
+	var query interface{} = filter
+	key, version, versioned := field.VersionOf(h)
+	if versioned = versioned && !upsert; versioned {
+		query = andFilter(filter, versionFilter(key, version))
+		if update, err = incVersion(update, key); err != nil {
+			return
 		}
 	}
 
-	res, err := c.collection.UpdateOne(ctx, bson.M{"_id": id}, update, updateOpts)
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.UpdateOne, query, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.UpdateOne(ctx, query, update, updateOpts)
+		setUpdateCounts(op, res)
+		return
+	})
 	if res != nil && res.MatchedCount == 0 {
 		err = ErrNoSuchDocuments
+		if versioned {
+			err = c.versionConflict(ctx, filter)
+		}
 	}
 	if err != nil {
 		return err
 	}
-	if len(opts) > 0 && opts[0].UpdateHook != nil {
-		if err = middleware.Do(ctx, opts[0].UpdateHook, operator.AfterUpdate); err != nil {
-			return
-		}
+	if versioned {
+		field.UpdateVersion(h, version+1)
+	}
+	hookOp.UpdateResult = res
+	if err = c.afterHook(ctx, h, operator.AfterUpdate); err != nil {
+		return
 	}
 	return err
 }
@@ -267,28 +503,40 @@ func (c *Collection) UpdateId(ctx context.Context, id interface{}, update interf
 // The matchedCount is 0 in UpdateResult if no document updated
 // Reference: https://docs.mongodb.com/manual/reference/operator/update/
 func (c *Collection) UpdateAll(ctx context.Context, filter interface{}, update interface{}, opts ...opts.UpdateOptions) (result *UpdateResult, err error) {
+	ctx = c.bindSession(ctx)
 	updateOpts := options.Update()
+	var h interface{}
 	if len(opts) > 0 {
 		if opts[0].UpdateOptions != nil {
 			updateOpts = opts[0].UpdateOptions
 		}
-		if opts[0].UpdateHook != nil {
-			if err = middleware.Do(ctx, opts[0].UpdateHook, operator.BeforeUpdate); err != nil {
-				return
-			}
-		}
+		h = opts[0].UpdateHook
+	}
+	ctx, hookOp := c.withOperation(ctx, filter, update, updateOpts)
+	if err = c.beforeHook(ctx, h, operator.BeforeUpdate); err != nil {
+		return
+	}
+	if err = validator.Update(c.model, update); err != nil {
+		return
+	}
+	if update, err = withUpdateFields(c.registry, c.model, filter, update, updateOpts.Upsert != nil && *updateOpts.Upsert); err != nil {
+		return
 	}
-	res, err := c.collection.UpdateMany(ctx, filter, update, updateOpts)
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.UpdateMany, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.UpdateMany(ctx, filter, update, updateOpts)
+		setUpdateCounts(op, res)
+		return
+	})
 	if res != nil {
 		result = translateUpdateResult(res)
 	}
 	if err != nil {
 		return
 	}
-	if len(opts) > 0 && opts[0].UpdateHook != nil {
-		if err = middleware.Do(ctx, opts[0].UpdateHook, operator.AfterUpdate); err != nil {
-			return
-		}
+	hookOp.UpdateResult = res
+	if err = c.afterHook(ctx, h, operator.AfterUpdate); err != nil {
+		return
 	}
 	return
 }
@@ -296,7 +544,10 @@ func (c *Collection) UpdateAll(ctx context.Context, filter interface{}, update i
 // ReplaceOne executes an update command to update at most one document in the collection.
 // If UpdateHook in opts is set, hook works on it, otherwise hook try the doc as hook
 // Expect type of the doc is the define of user's document
+// If doc has the custom Version field, the document is replaced only if its version equals to the one of doc,
+// and the version of doc is increased, otherwise ErrVersionConflict is returned
 func (c *Collection) ReplaceOne(ctx context.Context, filter interface{}, doc interface{}, opts ...opts.ReplaceOptions) (err error) {
+	ctx = c.bindSession(ctx)
 	h := doc
 	replaceOpts := options.Replace()
 
@@ -309,17 +560,36 @@ func (c *Collection) ReplaceOne(ctx context.Context, filter interface{}, doc int
 			h = opts[0].UpdateHook
 		}
 	}
-	if err = middleware.Do(ctx, doc, operator.BeforeReplace, h); err != nil {
+	ctx, hookOp := c.withOperation(ctx, filter, doc, replaceOpts)
+	if err = c.beforeHook(ctx, doc, operator.BeforeReplace, h); err != nil {
 		return
 	}
-	res, err := c.collection.ReplaceOne(ctx, filter, doc, replaceOpts)
+	query := filter
+	key, version, versioned := field.VersionOf(doc)
+	if versioned {
+		query = andFilter(filter, versionFilter(key, version))
+		field.UpdateVersion(doc, version+1)
+	}
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.ReplaceOne, query, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.ReplaceOne(ctx, query, doc, replaceOpts)
+		setUpdateCounts(op, res)
+		return
+	})
 	if res != nil && res.MatchedCount == 0 {
 		err = ErrNoSuchDocuments
+		if versioned {
+			err = c.versionConflict(ctx, filter)
+		}
 	}
 	if err != nil {
+		if versioned {
+			field.UpdateVersion(doc, version)
+		}
 		return err
 	}
-	if err = middleware.Do(ctx, doc, operator.AfterReplace, h); err != nil {
+	hookOp.UpdateResult = res
+	if err = c.afterHook(ctx, doc, operator.AfterReplace, h); err != nil {
 		return
 	}
 
@@ -330,46 +600,72 @@ func (c *Collection) ReplaceOne(ctx context.Context, filter interface{}, doc int
 // if filter is bson.M{}，DeleteOne will delete one document in collection
 // Reference: https://docs.mongodb.com/manual/reference/command/delete/
 func (c *Collection) Remove(ctx context.Context, filter interface{}, opts ...opts.RemoveOptions) (err error) {
+	ctx = c.bindSession(ctx)
 	deleteOptions := options.Delete()
+	var h interface{}
 	if len(opts) > 0 {
 		if opts[0].DeleteOptions != nil {
 			deleteOptions = opts[0].DeleteOptions
 		}
-		if opts[0].RemoveHook != nil {
-			if err = middleware.Do(ctx, opts[0].RemoveHook, operator.BeforeRemove); err != nil {
-				return err
+		h = opts[0].RemoveHook
+	}
+	ctx, hookOp := c.withOperation(ctx, filter, nil, deleteOptions)
+	if err = c.beforeHook(ctx, h, operator.BeforeRemove); err != nil {
+		return err
+	}
+	var res *mongo.DeleteResult
+	if c.softDelete != "" {
+		res, err = c.softRemove(ctx, filter, false, deleteOptions)
+	} else {
+		err = intercept(ctx, c.collection, interceptor.DeleteOne, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+			res, err = c.collection.DeleteOne(ctx, filter, deleteOptions)
+			if res != nil {
+				op.Deleted = res.DeletedCount
 			}
-		}
+			return
+		})
 	}
-	res, err := c.collection.DeleteOne(ctx, filter, deleteOptions)
 	if res != nil && res.DeletedCount == 0 {
 		err = ErrNoSuchDocuments
 	}
 	if err != nil {
 		return err
 	}
-	if len(opts) > 0 && opts[0].RemoveHook != nil {
-		if err = middleware.Do(ctx, opts[0].RemoveHook, operator.AfterRemove); err != nil {
-			return err
-		}
+	hookOp.DeleteResult = res
+	if err = c.afterHook(ctx, h, operator.AfterRemove); err != nil {
+		return err
 	}
 	return err
 }
 
 // RemoveId executes a delete command to delete at most one document from the collection.
 func (c *Collection) RemoveId(ctx context.Context, id interface{}, opts ...opts.RemoveOptions) (err error) {
+	ctx = c.bindSession(ctx)
 	deleteOptions := options.Delete()
+	var h interface{}
 	if len(opts) > 0 {
 		if opts[0].DeleteOptions != nil {
 			deleteOptions = opts[0].DeleteOptions
 		}
-		if opts[0].RemoveHook != nil {
-			if err = middleware.Do(ctx, opts[0].RemoveHook, operator.BeforeRemove); err != nil {
-				return err
+		h = opts[0].RemoveHook
+	}
+	filter := bson.M{"_id": id}
+	ctx, hookOp := c.withOperation(ctx, filter, nil, deleteOptions)
+	if err = c.beforeHook(ctx, h, operator.BeforeRemove); err != nil {
+		return err
+	}
+	var res *mongo.DeleteResult
+	if c.softDelete != "" {
+		res, err = c.softRemove(ctx, filter, false, deleteOptions)
+	} else {
+		err = intercept(ctx, c.collection, interceptor.DeleteOne, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+			res, err = c.collection.DeleteOne(ctx, filter, deleteOptions)
+			if res != nil {
+				op.Deleted = res.DeletedCount
 			}
-		}
+			return
+		})
 	}
-	res, err := c.collection.DeleteOne(ctx, bson.M{"_id": id}, deleteOptions)
 	if res != nil && res.DeletedCount == 0 {
 		err = ErrNoSuchDocuments
 	}
@@ -377,10 +673,9 @@ func (c *Collection) RemoveId(ctx context.Context, id interface{}, opts ...opts.
 		return err
 	}
 
-	if len(opts) > 0 && opts[0].RemoveHook != nil {
-		if err = middleware.Do(ctx, opts[0].RemoveHook, operator.AfterRemove); err != nil {
-			return err
-		}
+	hookOp.DeleteResult = res
+	if err = c.afterHook(ctx, h, operator.AfterRemove); err != nil {
+		return err
 	}
 	return err
 }
@@ -389,42 +684,89 @@ func (c *Collection) RemoveId(ctx context.Context, id interface{}, opts ...opts.
 // If filter is bson.M{}，all ducuments in Collection will be deleted
 // Reference: https://docs.mongodb.com/manual/reference/command/delete/
 func (c *Collection) RemoveAll(ctx context.Context, filter interface{}, opts ...opts.RemoveOptions) (result *DeleteResult, err error) {
+	ctx = c.bindSession(ctx)
 	deleteOptions := options.Delete()
+	var h interface{}
 	if len(opts) > 0 {
 		if opts[0].DeleteOptions != nil {
 			deleteOptions = opts[0].DeleteOptions
 		}
-		if opts[0].RemoveHook != nil {
-			if err = middleware.Do(ctx, opts[0].RemoveHook, operator.BeforeRemove); err != nil {
-				return
+		h = opts[0].RemoveHook
+	}
+	ctx, hookOp := c.withOperation(ctx, filter, nil, deleteOptions)
+	if err = c.beforeHook(ctx, h, operator.BeforeRemove); err != nil {
+		return
+	}
+	var res *mongo.DeleteResult
+	if c.softDelete != "" {
+		res, err = c.softRemove(ctx, filter, true, deleteOptions)
+	} else {
+		err = intercept(ctx, c.collection, interceptor.DeleteMany, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+			res, err = c.collection.DeleteMany(ctx, filter, deleteOptions)
+			if res != nil {
+				op.Deleted = res.DeletedCount
 			}
-		}
+			return
+		})
 	}
-	res, err := c.collection.DeleteMany(ctx, filter, deleteOptions)
 	if res != nil {
 		result = &DeleteResult{DeletedCount: res.DeletedCount}
 	}
 	if err != nil {
 		return
 	}
-	if len(opts) > 0 && opts[0].RemoveHook != nil {
-		if err = middleware.Do(ctx, opts[0].RemoveHook, operator.AfterRemove); err != nil {
-			return
-		}
+	hookOp.DeleteResult = res
+	if err = c.afterHook(ctx, h, operator.AfterRemove); err != nil {
+		return
 	}
 	return
 }
 
 // Aggregate executes an aggregate command against the collection and returns a AggregateI to get resulting documents.
+// If soft delete is enabled, the soft deleted documents are excluded unless AggregateI.WithDeleted is called,
+// the pipeline must be a slice of stages then, and the $match stage excluding them is added in front of it, or
+// after its first stage if it's $geoNear, $search or $vectorSearch. The pipeline starting with the stage reading
+// no documents of collection, like $collStats, $indexStats and $documents, is not changed.
 func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...opts.AggregateOptions) AggregateI {
+	ctx = c.bindSession(ctx)
 	return &Aggregate{
 		ctx:        ctx,
 		collection: c.collection,
 		pipeline:   pipeline,
 		options:    opts,
+		softDelete: c.softDelete,
 	}
 }
 
+// Restore restores the soft deleted documents matching filter by unsetting the deletion time
+// ErrSoftDeleteNotEnabled is returned if soft delete is not enabled on the collection
+func (c *Collection) Restore(ctx context.Context, filter interface{}) (result *UpdateResult, err error) {
+	if c.softDelete == "" {
+		return nil, ErrSoftDeleteNotEnabled
+	}
+	ctx = c.bindSession(ctx)
+	filter = andFilter(filter, bson.M{c.softDelete: bson.M{operator.Ne: nil}})
+	update := bson.M{operator.Unset: bson.M{c.softDelete: ""}}
+	var res *mongo.UpdateResult
+	err = intercept(ctx, c.collection, interceptor.UpdateMany, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = c.collection.UpdateMany(ctx, filter, update)
+		setUpdateCounts(op, res)
+		return
+	})
+	if res != nil {
+		result = translateUpdateResult(res)
+	}
+	return
+}
+
+// HardRemove deletes the documents matching filter from the collection even if soft delete is enabled
+// Reference: RemoveAll
+func (c *Collection) HardRemove(ctx context.Context, filter interface{}, opts ...opts.RemoveOptions) (*DeleteResult, error) {
+	hard := *c
+	hard.softDelete = ""
+	return hard.RemoveAll(ctx, filter, opts...)
+}
+
 // ensureIndex create multiple indexes on the collection and returns the names of
 // Example：indexes = []string{"idx1", "-idx2", "idx3,idx4"}
 // Three indexes will be created, index idx1 with ascending order, index idx2 with descending order, idex3 and idex4 are Compound ascending sort index
@@ -566,6 +908,97 @@ func (c *Collection) Watch(ctx context.Context, pipeline interface{}, opts ...*o
 	return c.collection.Watch(ctx, pipeline, changeStreamOption)
 }
 
+// Hooks returns the hook functions of collection, they are called in every operation on the collection
+// with the same opType, after the middlewares. The Collections of the same database and collection name got from
+// the same Client share the hooks, for example:
+//
+//	coll.Hooks().BeforeInsert(func(ctx context.Context, doc interface{}) error {
+//		return setTenant(ctx, doc)
+//	})
+func (c *Collection) Hooks() *hook.Hooks {
+	return c.hooks
+}
+
+// beforeHook calls the middlewares and hook functions before operation, see doHooks
+func (c *Collection) beforeHook(ctx context.Context, doc interface{}, opType operator.OpType, opts ...interface{}) error {
+	return doHooks(ctx, c.hooks, c.model, doc, opType, opts...)
+}
+
+// afterHook calls the middlewares and hook functions after write operation, see doAfterHook
+func (c *Collection) afterHook(ctx context.Context, doc interface{}, opType operator.OpType, opts ...interface{}) error {
+	return doAfterHook(ctx, c.hooks, c.model, doc, opType, opts...)
+}
+
+// withOperation returns ctx carrying the descriptor of write operation for hooks, see hook.Operation
+func (c *Collection) withOperation(ctx context.Context, filter, update, opts interface{}) (context.Context, *hook.Operation) {
+	op := &hook.Operation{Collection: c.collection.Name(), Filter: filter, Update: update, Options: opts}
+	return hook.WithOperation(ctx, op), op
+}
+
+// softRemove sets the deletion time of the documents matching filter which are not soft deleted yet,
+// at most one document is updated if many is false, the DeletedCount of result is the number of them
+func (c *Collection) softRemove(ctx context.Context, filter interface{}, many bool, deleteOptions *options.DeleteOptions) (*mongo.DeleteResult, error) {
+	filter = excludeDeleted(filter, c.softDelete)
+	update := c.softDeleteUpdate()
+	updateOpts := options.Update()
+	if deleteOptions.Collation != nil {
+		updateOpts.SetCollation(deleteOptions.Collation)
+	}
+	if deleteOptions.Hint != nil {
+		updateOpts.SetHint(deleteOptions.Hint)
+	}
+	kind := interceptor.UpdateOne
+	if many {
+		kind = interceptor.UpdateMany
+	}
+	var res *mongo.UpdateResult
+	err := intercept(ctx, c.collection, kind, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		if many {
+			res, err = c.collection.UpdateMany(ctx, filter, update, updateOpts)
+		} else {
+			res, err = c.collection.UpdateOne(ctx, filter, update, updateOpts)
+		}
+		setUpdateCounts(op, res)
+		return
+	})
+	if res == nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: res.ModifiedCount}, err
+}
+
+// softDeleteUpdate returns the update setting the deletion time of soft delete to now
+func (c *Collection) softDeleteUpdate() bson.M {
+	return bson.M{operator.Set: bson.M{c.softDelete: Now()}}
+}
+
+// versionConflict returns ErrVersionConflict if a document matches filter, which means its version is changed
+// by others, otherwise ErrNoSuchDocuments
+func (c *Collection) versionConflict(ctx context.Context, filter interface{}) error {
+	var n int64
+	err := intercept(ctx, c.collection, interceptor.CountDocuments, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		n, err = c.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		op.Matched = n
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrVersionConflict
+	}
+	return ErrNoSuchDocuments
+}
+
+// bindSession binds the session of collection to ctx, if the collection is got from UnitOfWork
+// and ctx has no session
+func (c *Collection) bindSession(ctx context.Context) context.Context {
+	if c.session == nil || mongo.SessionFromContext(ctx) != nil {
+		return ctx
+	}
+	return mongo.NewSessionContext(ctx, c.session)
+}
+
 // translateUpdateResult translates mongo update result to qmgo define UpdateResult
 func translateUpdateResult(res *mongo.UpdateResult) (result *UpdateResult) {
 	result = &UpdateResult{
@@ -16,6 +16,7 @@ package qmgo
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,6 +24,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/qiniu/qmgo/field"
 	"github.com/qiniu/qmgo/operator"
 	"github.com/qiniu/qmgo/options"
 )
@@ -804,3 +806,222 @@ func TestChangeStream(t *testing.T) {
 	<-doneChane
 
 }
+
+func TestUpsertByModel(t *testing.T) {
+	ast := require.New(t)
+
+	u := &UserField{Name: "Lucas", Age: 7}
+	u.Id = primitive.NewObjectID()
+	filter, update, err := upsertByModel(bson.DefaultRegistry, []string{"name"}, u)
+	ast.NoError(err)
+	ast.Len(filter, 1)
+	ast.Equal("name", filter[0].Key)
+	ast.Equal(operator.Set, update[0].Key)
+	ast.Equal(operator.SetOnInsert, update[1].Key)
+	var setOnInsert []string
+	for _, e := range update[1].Value.(bson.D) {
+		setOnInsert = append(setOnInsert, e.Key)
+	}
+	ast.Equal([]string{"_id", "createAt", "myId", "createTimeAt"}, setOnInsert)
+
+	_, _, err = upsertByModel(bson.DefaultRegistry, []string{"sku"}, u)
+	ast.Equal(ErrUpsertKeyMissing, err)
+
+	ast.Nil(sliceElemRefs(u))
+	us := []UserField{{Name: "Lucas"}}
+	refs := sliceElemRefs(&us)
+	ast.Same(&us[0], refs[0])
+}
+
+type upsertMeta struct {
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Source    string    `bson:"source"`
+}
+
+type nestedUpsertUser struct {
+	Id   primitive.ObjectID `bson:"_id"`
+	Name string             `bson:"name"`
+	Meta upsertMeta         `bson:"meta"`
+}
+
+func (u *nestedUpsertUser) CustomFields() field.CustomFieldsBuilder {
+	return field.NewCustom().SetId("Id").SetCreateAt("Meta.CreatedAt").SetUpdateAt("Meta.UpdatedAt")
+}
+
+func TestUpsertByModel_Nested(t *testing.T) {
+	ast := require.New(t)
+
+	u := &nestedUpsertUser{Id: primitive.NewObjectID(), Name: "Lucas", Meta: upsertMeta{Source: "app"}}
+	_, update, err := upsertByModel(bson.DefaultRegistry, []string{"name"}, u)
+	ast.NoError(err)
+	keys := func(d interface{}) (keys []string) {
+		for _, e := range d.(bson.D) {
+			keys = append(keys, e.Key)
+		}
+		return
+	}
+	ast.Equal(operator.Set, update[0].Key)
+	ast.Equal([]string{"name", "meta.updatedAt", "meta.source"}, keys(update[0].Value))
+	ast.Equal(operator.SetOnInsert, update[1].Key)
+	ast.Equal([]string{"_id", "meta.createdAt"}, keys(update[1].Value))
+}
+
+func TestCollection_UpsertManyBy_Nested(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	_, err := cli.UpsertManyBy(ctx, []string{"name"}, []nestedUpsertUser{{Name: "Lucas"}})
+	ast.NoError(err)
+	var first nestedUpsertUser
+	ast.NoError(cli.Find(ctx, bson.M{"name": "Lucas"}).One(&first))
+	ast.False(first.Meta.CreatedAt.IsZero())
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = cli.UpsertManyBy(ctx, []string{"name"}, []nestedUpsertUser{{Name: "Lucas", Meta: upsertMeta{Source: "import"}}})
+	ast.NoError(err)
+	var second nestedUpsertUser
+	ast.NoError(cli.Find(ctx, bson.M{"name": "Lucas"}).One(&second))
+	// the create time is kept, and the other fields of meta are updated
+	ast.Equal(first.Meta.CreatedAt, second.Meta.CreatedAt)
+	ast.True(second.Meta.UpdatedAt.After(first.Meta.UpdatedAt))
+	ast.Equal("import", second.Meta.Source)
+	ast.Equal(first.Id, second.Id)
+}
+
+func TestCollection_UpsertManyBy(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	existing := &UserField{Name: "Lucas", Age: 7}
+	_, err := cli.InsertOne(ctx, existing)
+	ast.NoError(err)
+
+	docs := []UserField{{Name: "Lucas", Age: 8}, {Name: "Alice", Age: 9}}
+	result, err := cli.UpsertManyBy(ctx, []string{"name"}, docs)
+	ast.NoError(err)
+	ast.Equal([]int{0}, result.Updated)
+	ast.Len(result.InsertedIDs, 1)
+	ast.Equal(docs[1].Id, result.InsertedIDs[1])
+	ast.Equal(int64(1), result.ModifiedCount)
+
+	// id and createAt of existing document are kept
+	var res UserField
+	ast.NoError(cli.Find(ctx, bson.M{"name": "Lucas"}).One(&res))
+	ast.Equal(existing.Id, res.Id)
+	ast.Equal(existing.MyId, res.MyId)
+	ast.Equal(8, res.Age)
+	n, err := cli.Find(ctx, bson.M{}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(2), n)
+
+	_, err = cli.UpsertManyBy(ctx, nil, docs)
+	ast.Equal(ErrUpsertKeyMissing, err)
+	_, err = cli.UpsertManyBy(ctx, []string{"name"}, docs[0])
+	ast.Equal(ErrNotValidSliceToInsert, err)
+}
+
+type softDeleteUser struct {
+	field.SoftDelete `bson:",inline"`
+
+	Name string `bson:"name"`
+	Age  int    `bson:"age"`
+}
+
+func TestCollection_SoftDelete(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	coll := cli.Database.Collection("test_soft_delete", &options.CollectionOptions{Model: &softDeleteUser{}})
+	defer coll.DropCollection(ctx)
+
+	_, err := coll.InsertMany(ctx, []softDeleteUser{{Name: "Lucas", Age: 7}, {Name: "Alice", Age: 8}, {Name: "Joe", Age: 9}})
+	ast.NoError(err)
+
+	ast.NoError(coll.Remove(ctx, bson.M{"name": "Lucas"}))
+	ast.Equal(ErrNoSuchDocuments, coll.Remove(ctx, bson.M{"name": "Lucas"}))
+	res, err := coll.RemoveAll(ctx, bson.M{"age": bson.M{operator.Gte: 8}})
+	ast.NoError(err)
+	ast.Equal(int64(2), res.DeletedCount)
+
+	n, err := coll.Find(ctx, bson.M{}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(0), n)
+	var users []softDeleteUser
+	ast.NoError(coll.Find(ctx, bson.M{}).WithDeleted().All(&users))
+	ast.Len(users, 3)
+	ast.NotNil(users[0].DeletedAt)
+	ast.NoError(coll.Aggregate(ctx, NewPipeline().Match(bson.M{})).All(&users))
+	ast.Len(users, 0)
+	ast.NoError(coll.Aggregate(ctx, NewPipeline().Match(bson.M{})).WithDeleted().All(&users))
+	ast.Len(users, 3)
+
+	ur, err := coll.Restore(ctx, bson.M{"name": "Lucas"})
+	ast.NoError(err)
+	ast.Equal(int64(1), ur.ModifiedCount)
+	var u softDeleteUser
+	ast.NoError(coll.Find(ctx, bson.M{"name": "Lucas"}).One(&u))
+	ast.Nil(u.DeletedAt)
+
+	res, err = coll.HardRemove(ctx, bson.M{"name": bson.M{operator.In: []string{"Lucas", "Alice"}}})
+	ast.NoError(err)
+	ast.Equal(int64(2), res.DeletedCount)
+	n, err = coll.Find(ctx, bson.M{}).WithDeleted().Count()
+	ast.NoError(err)
+	ast.Equal(int64(1), n)
+
+	// TypedCollection detects the soft delete field of T
+	typed := As[softDeleteUser](cli.Database.Collection("test_soft_delete"))
+	_, err = typed.InsertOne(ctx, softDeleteUser{Name: "Bob"})
+	ast.NoError(err)
+	ast.NoError(typed.Remove(ctx, bson.M{"name": "Bob"}))
+	n, err = typed.Find(ctx, bson.M{}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(0), n)
+	n, err = typed.Find(ctx, bson.M{}).WithDeleted().Count()
+	ast.NoError(err)
+	ast.Equal(int64(2), n)
+
+	// Apply with Remove and EstimatedCount
+	n, err = coll.Find(ctx, bson.M{}).EstimatedCount()
+	ast.NoError(err)
+	ast.Equal(int64(0), n)
+	_, err = coll.Restore(ctx, bson.M{"name": "Bob"})
+	ast.NoError(err)
+	n, err = coll.Find(ctx, bson.M{}).EstimatedCount()
+	ast.NoError(err)
+	ast.Equal(int64(1), n)
+	ast.NoError(coll.Find(ctx, bson.M{"name": "Bob"}).Apply(Change{Remove: true}, &u))
+	ast.Equal("Bob", u.Name)
+	ast.Nil(u.DeletedAt)
+	ast.Equal(ErrNoSuchDocuments, coll.Find(ctx, bson.M{"name": "Bob"}).Apply(Change{Remove: true}, &u))
+	ast.NoError(coll.Find(ctx, bson.M{"name": "Bob"}).WithDeleted().One(&u))
+	ast.NotNil(u.DeletedAt)
+	n, err = coll.Find(ctx, bson.M{}).WithDeleted().EstimatedCount()
+	ast.NoError(err)
+	ast.Equal(int64(2), n)
+
+	// bulk removes soft delete too, the documents can be restored
+	br, err := coll.Bulk().Remove(bson.M{"name": "Joe"}).RemoveAll(bson.M{"name": "Bob"}).Run(ctx)
+	ast.NoError(err)
+	ast.Equal(int64(0), br.DeletedCount)
+	ast.Equal(int64(1), br.ModifiedCount)
+	n, err = coll.Find(ctx, bson.M{"name": "Joe"}).WithDeleted().Count()
+	ast.NoError(err)
+	ast.Equal(int64(1), n)
+	ur, err = coll.Restore(ctx, bson.M{"name": "Joe"})
+	ast.NoError(err)
+	ast.Equal(int64(1), ur.ModifiedCount)
+	ast.NoError(coll.Find(ctx, bson.M{"name": "Joe"}).One(&u))
+	ast.Nil(u.DeletedAt)
+
+	_, err = cli.Restore(ctx, bson.M{})
+	ast.Equal(ErrSoftDeleteNotEnabled, err)
+}
@@ -15,7 +15,10 @@ package qmgo
 
 import (
 	"context"
+	"sync"
 
+	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/hook"
 	"github.com/qiniu/qmgo/options"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -27,21 +30,42 @@ type Database struct {
 	database *mongo.Database
 
 	registry *bsoncodec.Registry
+	hooks    *sync.Map
 }
 
 // Collection gets collection from database
+// Soft delete is enabled on the collection if SoftDeleteField of opts is set or Model implements field.SoftDeleteHook
 func (d *Database) Collection(name string, opts ...*options.CollectionOptions) *Collection {
 	var cp *mongo.Collection
 	var opt = make([]*officialOpts.CollectionOptions, 0, len(opts))
+	var model interface{}
+	var softDelete string
 	for _, o := range opts {
 		opt = append(opt, o.CollectionOptions)
+		if o.Model != nil {
+			model = o.Model
+		}
+		if o.SoftDeleteField != "" {
+			softDelete = o.SoftDeleteField
+		}
 	}
 	collOpt := officialOpts.MergeCollectionOptions(opt...)
 	cp = d.database.Collection(name, collOpt)
 
+	if softDelete == "" {
+		softDelete = field.SoftDeleteFieldOf(model)
+	}
+	hooks := hook.NewHooks()
+	if d.hooks != nil {
+		h, _ := d.hooks.LoadOrStore(d.database.Name()+"."+name, hooks)
+		hooks = h.(*hook.Hooks)
+	}
 	return &Collection{
 		collection: cp,
 		registry:   d.registry,
+		hooks:      hooks,
+		model:      model,
+		softDelete: softDelete,
 	}
 }
 
@@ -90,3 +114,13 @@ func (db *Database) CreateCollection(ctx context.Context, name string, opts ...o
 	}
 	return db.database.CreateCollection(ctx, name, option...)
 }
+
+// Watch returns a change stream for all changes to the corresponding database. See
+// https://docs.mongodb.com/manual/changeStreams/ for more information about change streams.
+func (d *Database) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	changeStreamOption := officialOpts.ChangeStream()
+	if len(opts) > 0 && opts[0].ChangeStreamOptions != nil {
+		changeStreamOption = opts[0].ChangeStreamOptions
+	}
+	return d.database.Watch(ctx, pipeline, changeStreamOption)
+}
@@ -15,8 +15,11 @@ package qmgo
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
+	"github.com/qiniu/qmgo/update"
+	"github.com/qiniu/qmgo/validator"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -42,9 +45,31 @@ var (
 	// ErrNotValidSliceToInsert return if insert argument is not valid slice
 	ErrNotValidSliceToInsert = errors.New("must be valid slice to insert")
 	// ErrReplacementContainUpdateOperators return if replacement document contain update operators
-	ErrReplacementContainUpdateOperators = errors.New("replacement document cannot contain keys beginning with '$'")
+	ErrReplacementContainUpdateOperators = update.ErrReplacementContainUpdateOperators
+	// ErrNotValidIndexModel return if the model to derive indexes is not a struct
+	ErrNotValidIndexModel = errors.New("index model must be a struct or a pointer to struct")
+	// ErrNotValidIndexTag return if the index tag of model is invalid
+	ErrNotValidIndexTag = errors.New("invalid index tag")
+	// ErrNotValidPageSize return if the page size is not positive
+	ErrNotValidPageSize = errors.New("page size must be positive")
+	// ErrNotValidPageNumber return if the page number is not positive
+	ErrNotValidPageNumber = errors.New("page number must be positive")
+	// ErrNotValidPageToken return if the page token is malformed or doesn't match the sort of query
+	ErrNotValidPageToken = errors.New("invalid page token")
+	// ErrUpsertKeyMissing return if the key fields of UpsertManyBy are empty or missing in document
+	ErrUpsertKeyMissing = errors.New("upsert key fields must be set and exist in every document")
+	// ErrSoftDeleteNotEnabled return if the soft delete operation is called on the collection without soft delete
+	ErrSoftDeleteNotEnabled = errors.New("soft delete is not enabled on the collection")
+	// ErrNotValidPipeline return if the pipeline is not a slice of stages, when it must be changed to exclude
+	// the soft deleted documents
+	ErrNotValidPipeline = errors.New("pipeline must be a slice of stages")
+	// ErrVersionConflict return if the document to replace or update exists but its version is changed
+	ErrVersionConflict = errors.New("version conflict, the document is modified by others")
 )
 
+// ValidationError return if the document or the fields of update are invalid, see validator.ValidationError
+type ValidationError = validator.ValidationError
+
 // IsErrNoDocuments check if err is no documents, both mongo-go-driver error and qmgo custom error
 // Deprecated, simply call if err == ErrNoSuchDocuments or if err == mongo.ErrNoDocuments
 func IsErrNoDocuments(err error) bool {
@@ -58,3 +83,54 @@ func IsErrNoDocuments(err error) bool {
 func IsDup(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "E11000")
 }
+
+// TransactionError is returned by transaction with TransactionPolicy, it reports how many attempts were made
+type TransactionError struct {
+	Attempts int
+	Err      error
+}
+
+// Error implements error
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("transaction failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// BulkWriteError is one failed write of Bulk
+type BulkWriteError struct {
+	// Index is the index of the operation in the queue of Bulk
+	Index int
+	// Model is the queued operation
+	Model     mongo.WriteModel
+	Code      int
+	Message   string
+	Duplicate bool
+}
+
+// BulkError is returned by Bulk.Run with the partial result if some writes fail
+// For ordered Bulk, the operations after the first failed one are not executed.
+type BulkError struct {
+	// WriteErrors are sorted by Index
+	WriteErrors []BulkWriteError
+	// Err is the first error returned by the driver, like mongo.BulkWriteException or network error
+	Err error
+}
+
+// Error implements the error interface
+func (e *BulkError) Error() string {
+	if len(e.WriteErrors) == 0 {
+		return fmt.Sprintf("bulk write failed: %v", e.Err)
+	}
+	first := e.WriteErrors[0]
+	return fmt.Sprintf("bulk write failed with %d write errors, the first at index %d: %s",
+		len(e.WriteErrors), first.Index, first.Message)
+}
+
+// Unwrap returns the error returned by the driver
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
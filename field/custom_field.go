@@ -25,18 +25,41 @@ type CustomFields struct {
 	createAt string
 	updateAt string
 	id       string
+	version  string
+
+	idGenerator  IdGenerator
+	timeEncoding TimeEncoding
+	location     *time.Location
 }
 
+// TimeEncoding defines how the custom time fields of int64 type are encoded
+// The fields of time.Time and primitive.DateTime are set as they are, and string fields are set in RFC3339
+type TimeEncoding int
+
+const (
+	// TimeUnix encodes time as Unix seconds, it is the default
+	TimeUnix TimeEncoding = iota
+	// TimeUnixMilli encodes time as Unix milliseconds
+	TimeUnixMilli
+	// TimeUnixNano encodes time as Unix nanoseconds
+	TimeUnixNano
+)
+
 // CustomFieldsHook defines the interface, CustomFields return custom field user want to change
 type CustomFieldsHook interface {
 	CustomFields() CustomFieldsBuilder
 }
 
 // CustomFieldsBuilder defines the interface which user use to set custom fields
+// The field name can be the path of nested or embedded struct field, like "Meta.CreatedAt"
 type CustomFieldsBuilder interface {
 	SetUpdateAt(fieldName string) CustomFieldsBuilder
 	SetCreateAt(fieldName string) CustomFieldsBuilder
 	SetId(fieldName string) CustomFieldsBuilder
+	SetVersion(fieldName string) CustomFieldsBuilder
+	SetIdGenerator(gen IdGenerator) CustomFieldsBuilder
+	SetTimeEncoding(encoding TimeEncoding) CustomFieldsBuilder
+	SetTimeLocation(loc *time.Location) CustomFieldsBuilder
 }
 
 // NewCustom creates new Builder which is used to set the custom fields
@@ -62,13 +85,38 @@ func (c *CustomFields) SetId(fieldName string) CustomFieldsBuilder {
 	return c
 }
 
+// SetVersion set the custom Version field, which enables optimistic locking on replace and update
+// The field must be an integer
+func (c *CustomFields) SetVersion(fieldName string) CustomFieldsBuilder {
+	c.version = fieldName
+	return c
+}
+
+// SetIdGenerator set the generator of the custom Id field, like UUIDv7() or Snowflake(node)
+func (c *CustomFields) SetIdGenerator(gen IdGenerator) CustomFieldsBuilder {
+	c.idGenerator = gen
+	return c
+}
+
+// SetTimeEncoding set the encoding of the custom time fields of int64 type
+func (c *CustomFields) SetTimeEncoding(encoding TimeEncoding) CustomFieldsBuilder {
+	c.timeEncoding = encoding
+	return c
+}
+
+// SetTimeLocation set the location of the custom time fields, like time.UTC, it is time.Local by default
+func (c *CustomFields) SetTimeLocation(loc *time.Location) CustomFieldsBuilder {
+	c.location = loc
+	return c
+}
+
 // CustomCreateTime changes the custom create time
 func (c CustomFields) CustomCreateTime(doc interface{}) {
 	if c.createAt == "" {
 		return
 	}
 	fieldName := c.createAt
-	setTime(doc, fieldName, false)
+	c.setTime(doc, fieldName, false)
 	return
 }
 
@@ -78,7 +126,7 @@ func (c CustomFields) CustomUpdateTime(doc interface{}) {
 		return
 	}
 	fieldName := c.updateAt
-	setTime(doc, fieldName, true)
+	c.setTime(doc, fieldName, true)
 	return
 }
 
@@ -88,60 +136,83 @@ func (c CustomFields) CustomId(doc interface{}) {
 		return
 	}
 	fieldName := c.id
-	setId(doc, fieldName)
+	c.setId(doc, fieldName)
 	return
 }
 
 // setTime changes the custom time fields
 // The overWrite defines if change value when the filed has valid value
-func setTime(doc interface{}, fieldName string, overWrite bool) {
+func (c CustomFields) setTime(doc interface{}, fieldName string, overWrite bool) {
 	if reflect.Ptr != reflect.TypeOf(doc).Kind() {
 		fmt.Println("not a point type")
 		return
 	}
-	e := reflect.ValueOf(doc).Elem()
-	ca := e.FieldByName(fieldName)
-	if ca.CanSet() {
-		tt := time.Now()
-		switch a := ca.Interface().(type) {
-		case time.Time:
-			if ca.Interface().(time.Time).IsZero() {
-				ca.Set(reflect.ValueOf(tt))
-			} else if overWrite {
-				ca.Set(reflect.ValueOf(tt))
-			}
-		case int64:
-			if ca.Interface().(int64) == 0 {
-				ca.SetInt(tt.Unix())
-			} else if overWrite {
-				ca.SetInt(tt.Unix())
-			}
+	ca, ok := lookupField(reflect.ValueOf(doc).Elem(), fieldName, true)
+	if !ok || !ca.CanSet() {
+		return
+	}
+	if !overWrite && !isZero(ca) {
+		return
+	}
+	tt := time.Now()
+	if c.location != nil {
+		tt = tt.In(c.location)
+	}
+	switch ca.Interface().(type) {
+	case time.Time:
+		ca.Set(reflect.ValueOf(tt))
+	case primitive.DateTime:
+		ca.Set(reflect.ValueOf(primitive.NewDateTimeFromTime(tt)))
+	case int64:
+		switch c.timeEncoding {
+		case TimeUnixMilli:
+			ca.SetInt(tt.UnixMilli())
+		case TimeUnixNano:
+			ca.SetInt(tt.UnixNano())
 		default:
-			fmt.Println("unsupported type to setTime", a)
+			ca.SetInt(tt.Unix())
 		}
+	case string:
+		ca.SetString(tt.Format(time.RFC3339))
+	default:
+		fmt.Println("unsupported type to setTime", ca.Interface())
 	}
 }
 
 // setId changes the custom Id fields
-func setId(doc interface{}, fieldName string) {
+// The id is generated by the IdGenerator if it is set, otherwise ObjectID or its hex string is used
+func (c CustomFields) setId(doc interface{}, fieldName string) {
 	if reflect.Ptr != reflect.TypeOf(doc).Kind() {
 		fmt.Println("not a point type")
 		return
 	}
-	e := reflect.ValueOf(doc).Elem()
-	ca := e.FieldByName(fieldName)
-	if ca.CanSet() {
-		switch a := ca.Interface().(type) {
-		case primitive.ObjectID:
-			if ca.Interface().(primitive.ObjectID).IsZero() {
-				ca.Set(reflect.ValueOf(primitive.NewObjectID()))
-			}
-		case string:
-			if ca.String() == "" {
-				ca.SetString(primitive.NewObjectID().Hex())
-			}
-		default:
-			fmt.Println("unsupported type to setId", a)
+	ca, ok := lookupField(reflect.ValueOf(doc).Elem(), fieldName, true)
+	if !ok || !ca.CanSet() || !isZero(ca) {
+		return
+	}
+	if c.idGenerator != nil {
+		id := reflect.ValueOf(c.idGenerator())
+		if id.Kind() == ca.Kind() && id.Type().ConvertibleTo(ca.Type()) {
+			ca.Set(id.Convert(ca.Type()))
+		} else {
+			fmt.Println("unsupported type to setId", ca.Interface())
 		}
+		return
+	}
+	switch ca.Interface().(type) {
+	case primitive.ObjectID:
+		ca.Set(reflect.ValueOf(primitive.NewObjectID()))
+	case string:
+		ca.SetString(primitive.NewObjectID().Hex())
+	default:
+		fmt.Println("unsupported type to setId", ca.Interface())
+	}
+}
+
+// isZero reports whether v is the zero value, time.Time is zero if its IsZero returns true
+func isZero(v reflect.Value) bool {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.IsZero()
 	}
+	return v.IsZero()
 }
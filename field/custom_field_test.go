@@ -15,7 +15,10 @@ package field
 
 import (
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -102,3 +105,103 @@ func TestCustomFieldsInvalid(t *testing.T) {
 	ast.Equal(0, u4.InvalidId)
 
 }
+
+type customMeta struct {
+	CreatedAt primitive.DateTime `bson:"createdAt"`
+	UpdatedAt string             `bson:"updatedAt"`
+}
+
+type CustomBase struct {
+	Id int64 `bson:"_id"`
+}
+
+type NestedUser struct {
+	CustomBase `bson:",inline"`
+	Meta       *customMeta `bson:"meta"`
+	UUID       string      `bson:"uuid"`
+	Millis     int64       `bson:"millis"`
+}
+
+func (u *NestedUser) CustomFields() CustomFieldsBuilder {
+	return NewCustom().SetId("Id").SetIdGenerator(Snowflake(1)).
+		SetCreateAt("Meta.CreatedAt").SetUpdateAt("Meta.UpdatedAt").SetTimeLocation(time.UTC)
+}
+
+func TestCustomFieldsOptions(t *testing.T) {
+	ast := require.New(t)
+
+	u := &NestedUser{}
+	c := u.CustomFields().(*CustomFields)
+	c.CustomId(u)
+	c.CustomCreateTime(u)
+	c.CustomUpdateTime(u)
+	ast.NotZero(u.Id)
+	ast.NotNil(u.Meta)
+	ast.NotZero(u.Meta.CreatedAt)
+	updatedAt, err := time.Parse(time.RFC3339, u.Meta.UpdatedAt)
+	ast.NoError(err)
+	ast.Equal(time.UTC, updatedAt.Location())
+
+	// the id is not changed
+	id := u.Id
+	c.CustomId(u)
+	ast.Equal(id, u.Id)
+
+	// the documents built in the same millisecond get different ids
+	ids := map[int64]bool{}
+	for i := 0; i < 100; i++ {
+		nu := &NestedUser{}
+		nu.CustomFields().(*CustomFields).CustomId(nu)
+		ast.False(ids[nu.Id])
+		ids[nu.Id] = true
+	}
+
+	// generator of wrong kind is ignored
+	NewCustom().SetId("UUID").SetIdGenerator(Snowflake(1)).(*CustomFields).CustomId(u)
+	ast.Equal("", u.UUID)
+	NewCustom().SetId("UUID").SetIdGenerator(UUIDv4()).(*CustomFields).CustomId(u)
+	ast.Len(u.UUID, 36)
+
+	before := time.Now().UnixMilli()
+	NewCustom().SetUpdateAt("Millis").SetTimeEncoding(TimeUnixMilli).(*CustomFields).CustomUpdateTime(u)
+	ast.True(u.Millis >= before && u.Millis <= time.Now().UnixMilli())
+	NewCustom().SetUpdateAt("Millis").SetTimeEncoding(TimeUnixNano).(*CustomFields).CustomUpdateTime(u)
+	ast.True(u.Millis > before*1e6)
+
+	// nested paths in bson keys
+	ast.Equal("_id", bsonKey(u, "Id"))
+	ast.Equal("meta.createdAt", bsonKey(u, "Meta.CreatedAt"))
+	ast.Equal("", bsonKey(u, "Meta.Unknown"))
+	ast.Equal("_id", bsonKey(u, "CustomBase.Id"))
+	ast.Equal([]string{"_id", "meta.createdAt"}, InsertOnlyFields(u))
+
+	// the same keys as the driver encodes
+	type embedded struct {
+		Name string `bson:"name"`
+	}
+	type Embedded struct {
+		Tags []customMeta `bson:"tags"`
+	}
+	type encoded struct {
+		embedded
+		Embedded
+		NestedUser `bson:",inline"`
+		Skip       string `bson:"-"`
+	}
+	raw, err := bson.Marshal(encoded{Embedded: Embedded{Tags: []customMeta{{}}}})
+	ast.NoError(err)
+	typ := reflect.TypeOf(encoded{})
+	for _, path := range []string{"Embedded.Tags[0].CreatedAt", "Id", "NestedUser.CustomBase.Id", "UUID"} {
+		key := BsonPath(typ, path)
+		_, err = bson.Raw(raw).LookupErr(strings.Split(key, ".")...)
+		ast.NoError(err, key)
+	}
+	ast.Equal("embedded.tags.0.createdAt", BsonPath(typ, "Embedded.Tags[0].CreatedAt"))
+	ast.Equal("", BsonPath(typ, "Name"))
+	ast.Equal("", BsonPath(typ, "Skip"))
+	ast.Equal("", BsonPath(typ, "Embedded.Tags[0"))
+
+	// nil pointer on the path is not allocated for lookup
+	_, ok := lookupField(reflect.ValueOf(&NestedUser{}).Elem(), "Meta.CreatedAt", false)
+	ast.False(ok)
+}
@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/qiniu/qmgo/operator"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 var nilTime time.Time
@@ -129,6 +130,101 @@ func beforeUpsert(doc interface{}) error {
 	return nil
 }
 
+// InsertOnlyFields returns the bson keys of the fields in doc which are only set on insert,
+// that is the id and createAt of DefaultField and CustomFields
+func InsertOnlyFields(doc interface{}) []string {
+	var keys []string
+	for _, path := range insertPaths(doc) {
+		if key := bsonKey(doc, path); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// UpdateFields returns the bson keys and values of the fields of model which are set on update,
+// that is the updateAt of DefaultField and CustomFields, the values are generated as if a document of model is updated
+func UpdateFields(model interface{}) bson.D {
+	doc, ok := newDoc(model)
+	if !ok {
+		return nil
+	}
+	_ = beforeUpdate(doc)
+	return fieldValues(doc, updatePaths(doc))
+}
+
+// InsertFields returns the bson keys and values of the fields of model which are only set on insert,
+// that is the id and createAt of DefaultField and CustomFields, the values are generated as if a document of model is inserted
+func InsertFields(model interface{}) bson.D {
+	doc, ok := newDoc(model)
+	if !ok {
+		return nil
+	}
+	_ = beforeInsert(doc)
+	return fieldValues(doc, insertPaths(doc))
+}
+
+// updatePaths returns the paths of the fields of doc set on update
+func updatePaths(doc interface{}) []string {
+	var paths []string
+	if _, ok := doc.(DefaultFieldHook); ok {
+		paths = append(paths, "UpdateAt")
+	}
+	if ih, ok := doc.(CustomFieldsHook); ok {
+		if fields, ok := ih.CustomFields().(*CustomFields); ok && fields.updateAt != "" {
+			paths = append(paths, fields.updateAt)
+		}
+	}
+	return paths
+}
+
+// insertPaths returns the paths of the fields of doc only set on insert
+func insertPaths(doc interface{}) []string {
+	var paths []string
+	if _, ok := doc.(DefaultFieldHook); ok {
+		paths = append(paths, "Id", "CreateAt")
+	}
+	if ih, ok := doc.(CustomFieldsHook); ok {
+		if fields, ok := ih.CustomFields().(*CustomFields); ok {
+			for _, path := range []string{fields.id, fields.createAt} {
+				if path != "" {
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// newDoc returns the pointer to a new document of model, which is a struct or a pointer to struct
+func newDoc(model interface{}) (interface{}, bool) {
+	t := reflect.TypeOf(model)
+	if t == nil {
+		return nil, false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	return reflect.New(t).Interface(), true
+}
+
+// fieldValues returns the bson keys and values of the fields at paths of doc
+func fieldValues(doc interface{}, paths []string) bson.D {
+	var values bson.D
+	for _, path := range paths {
+		key := bsonKey(doc, path)
+		v, ok := lookupField(reflect.ValueOf(doc).Elem(), path, false)
+		if key == "" || !ok {
+			continue
+		}
+		values = append(values, bson.E{Key: key, Value: v.Interface()})
+	}
+	return values
+}
+
 // do check if opType is supported and call fieldHandler
 func do(doc interface{}, opType operator.OpType) error {
 	if f, ok := fieldHandler[opType]; !ok {
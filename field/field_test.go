@@ -20,6 +20,7 @@ import (
 
 	"github.com/qiniu/qmgo/operator"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -268,3 +269,32 @@ func TestNilError(t *testing.T) {
 	ast.NoError(err)
 
 }
+
+func TestInsertOnlyFields(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal([]string{"_id", "createAt", "myId", "createTimeAt"}, InsertOnlyFields(&User{}))
+	ast.Nil(InsertOnlyFields(&struct{ Name string }{}))
+	ast.Equal("", bsonKey(&User{}, "Missing"))
+	ast.Equal("name", bsonKey(User{}, "Name"))
+}
+
+func TestUpdateFields(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Nil(UpdateFields(nil))
+	ast.Nil(UpdateFields(bson.M{}))
+	ast.Nil(UpdateFields(&struct{ Name string }{}))
+
+	fields := UpdateFields(&NestedUser{})
+	ast.Len(fields, 1)
+	ast.Equal("meta.updatedAt", fields[0].Key)
+	ast.NotEmpty(fields[0].Value)
+
+	fields = InsertFields(NestedUser{})
+	ast.Len(fields, 2)
+	ast.Equal("_id", fields[0].Key)
+	ast.NotZero(fields[0].Value)
+	ast.Equal("meta.createdAt", fields[1].Key)
+	ast.NotZero(fields[1].Value)
+}
@@ -0,0 +1,145 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// IdGenerator generates the value of the custom Id field
+// The value must be of the same kind as the field, like string for string field and int64 for int64 field
+type IdGenerator func() interface{}
+
+// UUIDv4 returns the IdGenerator generating random UUID strings
+func UUIDv4() IdGenerator {
+	return func() interface{} {
+		var b [16]byte
+		randomBytes(b[:])
+		return formatUUID(b, 4)
+	}
+}
+
+// UUIDv7 returns the IdGenerator generating UUID strings ordered by time, which have
+// the Unix milliseconds in the first 48 bits
+func UUIDv7() IdGenerator {
+	return func() interface{} {
+		var b [16]byte
+		putMillis(b[:], time.Now())
+		randomBytes(b[6:])
+		return formatUUID(b, 7)
+	}
+}
+
+// ULID returns the IdGenerator generating ULID strings, which are 26 characters in Crockford's base32
+// and ordered by time
+// Reference: https://github.com/ulid/spec
+func ULID() IdGenerator {
+	return func() interface{} {
+		var b [16]byte
+		putMillis(b[:], time.Now())
+		randomBytes(b[6:])
+		return encodeCrockford(b)
+	}
+}
+
+// snowflakeEpoch is the epoch of Snowflake ids, 2020-01-01 UTC
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+// Snowflake returns the IdGenerator generating int64 ids of snowflake style, which have 41 bits of milliseconds
+// since 2020-01-01 UTC, 10 bits of node and 12 bits of sequence in the same millisecond
+// The node must be unique among the processes generating ids, only its lowest 10 bits are used
+// The generators of the same node share the state in the process, so Snowflake can be called in CustomFields
+// for every document
+func Snowflake(node int64) IdGenerator {
+	node &= 0x3ff
+	s, _ := snowflakes.LoadOrStore(node, &snowflake{node: node})
+	return s.(*snowflake).next
+}
+
+// snowflakes is the state of Snowflake generators by node
+var snowflakes sync.Map
+
+// snowflake holds the state of Snowflake
+type snowflake struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64
+	sequence int64
+}
+
+// next returns the next snowflake id
+func (s *snowflake) next() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UnixMilli() - snowflakeEpoch
+	if now < s.last {
+		// the clock moves backwards, keep using the last millisecond
+		now = s.last
+	}
+	if now == s.last {
+		s.sequence = (s.sequence + 1) & 0xfff
+		if s.sequence == 0 {
+			// the sequence is exhausted, wait for the next millisecond
+			for now <= s.last {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli() - snowflakeEpoch
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.last = now
+	return now<<22 | s.node<<12 | s.sequence
+}
+
+// randomBytes fills b with random bytes
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("cannot generate random bytes: %w", err))
+	}
+}
+
+// putMillis puts the Unix milliseconds of t into the first 6 bytes of b in big endian
+func putMillis(b []byte, t time.Time) {
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b[:6], ms[2:])
+}
+
+// formatUUID sets the version and variant of b and formats it as UUID string
+func formatUUID(b [16]byte, version byte) string {
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// crockford is the alphabet of Crockford's base32
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeCrockford encodes the 128 bits of b into 26 characters of Crockford's base32
+func encodeCrockford(b [16]byte) string {
+	n := new(big.Int).SetBytes(b[:])
+	mask := big.NewInt(31)
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 5)
+	}
+	return string(out)
+}
@@ -0,0 +1,66 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdGenerator(t *testing.T) {
+	ast := require.New(t)
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([47])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	v4 := UUIDv4()().(string)
+	ast.Equal("4", uuid.FindStringSubmatch(v4)[1])
+	ast.NotEqual(v4, UUIDv4()())
+
+	v7 := UUIDv7()
+	first := v7().(string)
+	ast.Equal("7", uuid.FindStringSubmatch(first)[1])
+	time.Sleep(2 * time.Millisecond)
+	ast.True(v7().(string) > first)
+
+	ulid := ULID()
+	first = ulid().(string)
+	ast.Regexp(`^[0-9A-HJKMNP-TV-Z]{26}$`, first)
+	time.Sleep(2 * time.Millisecond)
+	ast.True(ulid().(string) > first)
+	ast.Equal("00000000000000000000000001", encodeCrockford([16]byte{15: 1}))
+	ast.Equal("7ZZZZZZZZZZZZZZZZZZZZZZZZZ", encodeCrockford([16]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}))
+
+	sf := Snowflake(3)
+	seen := map[int64]bool{}
+	last := int64(0)
+	for i := 0; i < 5000; i++ {
+		id := sf().(int64)
+		ast.False(seen[id])
+		ast.True(id > last)
+		ast.Equal(int64(3), id>>12&0x3ff)
+		seen[id] = true
+		last = id
+	}
+
+	// the generators of the same node share the sequence
+	ids := map[int64]bool{}
+	for i := 0; i < 1000; i++ {
+		id := Snowflake(1027)().(int64)
+		ast.False(ids[id])
+		ids[id] = true
+	}
+}
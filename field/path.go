@@ -0,0 +1,147 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"reflect"
+	"strings"
+)
+
+// lookupField returns the field at path of struct v, like "Meta.CreatedAt"
+// The fields of embedded structs can be accessed directly, and the nil struct pointers on the path
+// are allocated if alloc is true
+func lookupField(v reflect.Value, path string, alloc bool) (reflect.Value, bool) {
+	if path == "" {
+		return reflect.Value{}, false
+	}
+	for _, name := range strings.Split(path, ".") {
+		if v, _ = indirect(v, alloc); v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		f, ok := v.Type().FieldByName(name)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		for i, idx := range f.Index {
+			if i > 0 {
+				if v, _ = indirect(v, alloc); v.Kind() != reflect.Struct {
+					return reflect.Value{}, false
+				}
+			}
+			v = v.Field(idx)
+		}
+	}
+	return v, true
+}
+
+// indirect returns the value v points to, the nil pointer is allocated if alloc is true and it's settable
+func indirect(v reflect.Value, alloc bool) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if !alloc || !v.CanSet() {
+				return reflect.Value{}, false
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// bsonKey returns the dotted bson key of the field at path of doc, or "" if not found
+func bsonKey(doc interface{}, path string) string {
+	t := reflect.TypeOf(doc)
+	if t == nil {
+		return ""
+	}
+	return BsonPath(t, path)
+}
+
+// BsonPath returns the dotted bson key of the field at path of struct type t, like "meta.createdAt" of
+// "Meta.CreatedAt", or "" if the field is not found or not encoded
+// The fields of embedded structs can be accessed directly, and the inline structs add no key, like DefaultField
+// with `bson:",inline"`. The indexes of slices and maps are kept, like "addresses.0.city" of "Addresses[0].City"
+func BsonPath(t reflect.Type, path string) string {
+	if path == "" || t == nil {
+		return ""
+	}
+	var keys []string
+	for _, seg := range strings.Split(path, ".") {
+		name, index := seg, ""
+		if i := strings.Index(seg, "["); i >= 0 {
+			name, index = seg[:i], seg[i:]
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return ""
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return ""
+		}
+		// walk the embedded structs to the field
+		for _, idx := range f.Index {
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			sf := t.Field(idx)
+			key, inline := BsonName(sf)
+			if key == "-" {
+				return ""
+			}
+			if !inline {
+				keys = append(keys, key)
+			}
+			t = sf.Type
+		}
+		// indexes of slices and maps, like [0][key]
+		for index != "" {
+			end := strings.Index(index, "]")
+			if end < 0 {
+				return ""
+			}
+			keys = append(keys, index[1:end])
+			index = index[end+1:]
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+				t = t.Elem()
+			}
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+// BsonName returns the bson key of struct field f and whether it is inline, the same as the driver does:
+// the lowercase field name is used if the bson tag has no name, only the fields tagged with inline are inlined,
+// and the key is "-" if the field is unexported or ignored
+func BsonName(f reflect.StructField) (key string, inline bool) {
+	if f.PkgPath != "" {
+		return "-", false
+	}
+	parts := strings.Split(f.Tag.Get("bson"), ",")
+	key = parts[0]
+	for _, p := range parts[1:] {
+		if p == "inline" {
+			inline = true
+		}
+	}
+	if key == "" {
+		key = strings.ToLower(f.Name)
+	}
+	return key, inline
+}
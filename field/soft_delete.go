@@ -0,0 +1,58 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"reflect"
+	"time"
+)
+
+// DefaultSoftDeleteField is the bson key of the deletion time of SoftDelete
+const DefaultSoftDeleteField = "deletedAt"
+
+// SoftDeleteHook defines the interface of the model enabling soft delete,
+// SoftDeleteField returns the bson key of the deletion time
+// The deletion time is written as datetime and the documents whose field is null or missing are not deleted,
+// so the field must be *time.Time, or time.Time with omitempty, like DeletedAt of SoftDelete
+type SoftDeleteHook interface {
+	SoftDeleteField() string
+}
+
+// SoftDelete enables soft delete with the deletedAt field
+// import the SoftDelete in document struct to make it working
+type SoftDelete struct {
+	DeletedAt *time.Time `bson:"deletedAt,omitempty"`
+}
+
+// SoftDeleteField returns the bson key of the deletion time
+func (SoftDelete) SoftDeleteField() string {
+	return DefaultSoftDeleteField
+}
+
+// SoftDeleteFieldOf returns the bson key of the deletion time if model or the pointer to it
+// implements SoftDeleteHook, otherwise ""
+func SoftDeleteFieldOf(model interface{}) string {
+	if model == nil {
+		return ""
+	}
+	if ih, ok := model.(SoftDeleteHook); ok {
+		return ih.SoftDeleteField()
+	}
+	v := reflect.New(reflect.TypeOf(model))
+	v.Elem().Set(reflect.ValueOf(model))
+	if ih, ok := v.Interface().(SoftDeleteHook); ok {
+		return ih.SoftDeleteField()
+	}
+	return ""
+}
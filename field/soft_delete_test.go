@@ -0,0 +1,57 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type softDeleteUser struct {
+	SoftDelete `bson:",inline"`
+	Name       string `bson:"name"`
+}
+
+type customSoftDeleteUser struct {
+	RemovedAt time.Time `bson:"removedAt,omitempty"`
+}
+
+func (u *customSoftDeleteUser) SoftDeleteField() string {
+	return "removedAt"
+}
+
+func TestSoftDeleteFieldOf(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal("", SoftDeleteFieldOf(nil))
+	ast.Equal("", SoftDeleteFieldOf(&DefaultField{}))
+	ast.Equal("deletedAt", SoftDeleteFieldOf(&softDeleteUser{}))
+	ast.Equal("deletedAt", SoftDeleteFieldOf(softDeleteUser{}))
+	ast.Equal("removedAt", SoftDeleteFieldOf(&customSoftDeleteUser{}))
+	ast.Equal("removedAt", SoftDeleteFieldOf(customSoftDeleteUser{}))
+
+	// the deletion time of the document not deleted is missing, which matches {removedAt: null}
+	raw, err := bson.Marshal(customSoftDeleteUser{})
+	ast.NoError(err)
+	_, err = bson.Raw(raw).LookupErr("removedAt")
+	ast.Error(err)
+	var u customSoftDeleteUser
+	raw, err = bson.Marshal(bson.M{"removedAt": time.Now()})
+	ast.NoError(err)
+	ast.NoError(bson.Unmarshal(raw, &u))
+	ast.False(u.RemovedAt.IsZero())
+}
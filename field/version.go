@@ -0,0 +1,64 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"reflect"
+)
+
+// VersionOf returns the bson key and the value of the custom Version field of doc
+// ok is false if doc is not a pointer to struct implementing CustomFieldsHook with an integer Version field
+func VersionOf(doc interface{}) (key string, version int64, ok bool) {
+	v, ok := versionValue(doc)
+	if !ok {
+		return "", 0, false
+	}
+	key = bsonKey(doc, doc.(CustomFieldsHook).CustomFields().(*CustomFields).version)
+	if key == "" {
+		return "", 0, false
+	}
+	return key, v.Int(), true
+}
+
+// UpdateVersion sets the custom Version field of doc to version, it does nothing if doc has no version field
+func UpdateVersion(doc interface{}, version int64) {
+	if v, ok := versionValue(doc); ok {
+		v.SetInt(version)
+	}
+}
+
+// versionValue returns the settable value of the custom Version field of doc
+func versionValue(doc interface{}) (reflect.Value, bool) {
+	ih, ok := doc.(CustomFieldsHook)
+	if !ok || reflect.TypeOf(doc).Kind() != reflect.Ptr {
+		return reflect.Value{}, false
+	}
+	fields, ok := ih.CustomFields().(*CustomFields)
+	if !ok || fields.version == "" {
+		return reflect.Value{}, false
+	}
+	e := reflect.ValueOf(doc).Elem()
+	if e.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	v, ok := lookupField(e, fields.version, false)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v, v.CanSet()
+	}
+	return reflect.Value{}, false
+}
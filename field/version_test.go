@@ -0,0 +1,62 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package field
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type versionUser struct {
+	Name    string `bson:"name"`
+	Version int32  `bson:"ver"`
+}
+
+func (u *versionUser) CustomFields() CustomFieldsBuilder {
+	return NewCustom().SetVersion("Version")
+}
+
+type invalidVersionUser struct {
+	Version string
+}
+
+func (u *invalidVersionUser) CustomFields() CustomFieldsBuilder {
+	return NewCustom().SetVersion("Version")
+}
+
+func TestVersion(t *testing.T) {
+	ast := require.New(t)
+
+	u := &versionUser{Version: 3}
+	key, version, ok := VersionOf(u)
+	ast.True(ok)
+	ast.Equal("ver", key)
+	ast.Equal(int64(3), version)
+	UpdateVersion(u, 4)
+	ast.Equal(int32(4), u.Version)
+
+	// not pointer
+	_, _, ok = VersionOf(versionUser{})
+	ast.False(ok)
+	// not integer
+	_, _, ok = VersionOf(&invalidVersionUser{})
+	ast.False(ok)
+	UpdateVersion(&invalidVersionUser{}, 1)
+	// no version field
+	_, _, ok = VersionOf(&CustomUser{})
+	ast.False(ok)
+	_, _, ok = VersionOf(nil)
+	ast.False(ok)
+}
@@ -20,9 +20,11 @@ import (
 	"time"
 
 	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/options"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserField struct {
@@ -227,3 +229,105 @@ func TestFieldUpdateId(t *testing.T) {
 	ast.Equal(int64(0), findUi.UpdateTimeAt)
 	ast.Equal(time.Time{}, findUi.UpdateAt)
 }
+
+type VersionUser struct {
+	Id      primitive.ObjectID `bson:"_id"`
+	Name    string             `bson:"name"`
+	Version int64              `bson:"version"`
+}
+
+func (u *VersionUser) CustomFields() field.CustomFieldsBuilder {
+	return field.NewCustom().SetId("Id").SetVersion("Version")
+}
+
+func TestFieldVersion(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	u := &VersionUser{Name: "Lucas"}
+	_, err := cli.InsertOne(ctx, u)
+	ast.NoError(err)
+	stale := *u
+
+	// replace increases the version
+	u.Name = "Alice"
+	ast.NoError(cli.ReplaceOne(ctx, bson.M{"_id": u.Id}, u))
+	ast.Equal(int64(1), u.Version)
+	stale.Name = "Joe"
+	ast.Equal(ErrVersionConflict, cli.ReplaceOne(ctx, bson.M{"_id": u.Id}, &stale))
+	ast.Equal(int64(0), stale.Version)
+
+	// update checks the version of UpdateHook
+	ast.NoError(cli.UpdateId(ctx, u.Id, bson.M{"$set": bson.M{"name": "Bob"}}, options.UpdateOptions{UpdateHook: u}))
+	ast.Equal(int64(2), u.Version)
+	ast.Equal(ErrVersionConflict, cli.UpdateOne(ctx, bson.M{"_id": u.Id}, bson.M{"$set": bson.M{"name": "Joe"}}, options.UpdateOptions{UpdateHook: &stale}))
+	ast.Equal(ErrNoSuchDocuments, cli.UpdateId(ctx, primitive.NewObjectID(), bson.M{"$set": bson.M{"name": "Joe"}}, options.UpdateOptions{UpdateHook: u}))
+
+	var res VersionUser
+	ast.NoError(cli.Find(ctx, bson.M{"_id": u.Id}).One(&res))
+	ast.Equal("Bob", res.Name)
+	ast.Equal(int64(2), res.Version)
+
+	// the document without version field is the version 0
+	legacyId := primitive.NewObjectID()
+	_, err = cli.InsertOne(ctx, bson.M{"_id": legacyId, "name": "Legacy"})
+	ast.NoError(err)
+	legacy := &VersionUser{Id: legacyId, Name: "Legacy"}
+	ast.NoError(cli.UpdateId(ctx, legacyId, bson.M{"$set": bson.M{"name": "Legacy2"}}, options.UpdateOptions{UpdateHook: legacy}))
+	ast.Equal(int64(1), legacy.Version)
+	ast.NoError(cli.Find(ctx, bson.M{"_id": legacyId}).One(&res))
+	ast.Equal(int64(1), res.Version)
+
+	// the check is skipped for upsert
+	upsertId := primitive.NewObjectID()
+	ast.NoError(cli.UpdateId(ctx, upsertId, bson.M{"$set": bson.M{"name": "Upsert"}}, options.UpdateOptions{
+		UpdateOptions: officialOpts.Update().SetUpsert(true), UpdateHook: &VersionUser{Version: 5},
+	}))
+}
+
+func TestFieldOperatorUpdate(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	coll := cli.Database.Collection("test_operator_update", &options.CollectionOptions{Model: &UserField{}})
+	defer coll.DropCollection(ctx)
+
+	u := &UserField{Name: "Lucas", Age: 7}
+	u.UpdateAt = time.Now().Add(-time.Hour)
+	_, err := coll.InsertOne(ctx, u)
+	ast.NoError(err)
+	// the hooks set updateAt on insert, reset it
+	ast.NoError(coll.UpdateId(ctx, u.Id, bson.M{"$set": bson.M{"updateAt": time.Time{}, "updateTimeAt": 0}}))
+	var res UserField
+	ast.NoError(coll.Find(ctx, bson.M{"_id": u.Id}).One(&res))
+	ast.True(res.UpdateAt.IsZero())
+
+	ast.NoError(coll.UpdateOne(ctx, bson.M{"name": "Lucas"}, bson.M{"$set": bson.M{"age": 8}}))
+	ast.NoError(coll.Find(ctx, bson.M{"_id": u.Id}).One(&res))
+	ast.False(res.UpdateAt.IsZero())
+	ast.NotZero(res.UpdateTimeAt)
+
+	// upsert sets the id and create time on insert
+	ast.NoError(coll.UpdateOne(ctx, bson.M{"name": "Alice"}, bson.M{"$set": bson.M{"age": 9}}, options.UpdateOptions{
+		UpdateOptions: officialOpts.Update().SetUpsert(true),
+	}))
+	ast.NoError(coll.Find(ctx, bson.M{"name": "Alice"}).One(&res))
+	ast.False(res.Id.IsZero())
+	ast.False(res.CreateAt.IsZero())
+	ast.NotEmpty(res.MyId)
+
+	change := Change{Update: bson.M{"$set": bson.M{"age": 10}}, Upsert: true, ReturnNew: true}
+	ast.NoError(coll.Find(ctx, bson.M{"name": "Joe"}).Apply(change, &res))
+	ast.False(res.CreateAt.IsZero())
+	ast.False(res.UpdateAt.IsZero())
+
+	_, err = coll.Bulk().UpdateAll(bson.M{}, bson.M{"$set": bson.M{"updateTimeAt": 0}}).
+		UpdateId(u.Id, bson.M{"$set": bson.M{"age": 11}}).Run(ctx)
+	ast.NoError(err)
+	ast.NoError(coll.Find(ctx, bson.M{"_id": u.Id}).One(&res))
+	ast.NotZero(res.UpdateTimeAt)
+}
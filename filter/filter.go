@@ -0,0 +1,194 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package filter builds query filter documents with functions instead of raw bson.M
+// Every function returns bson.D, which can be passed to any API accepting a filter, like Collection.Find
+// Example: filter.And(filter.Eq("name", "Lucas"), filter.Gt("age", 7))
+// refer: https://docs.mongodb.com/manual/reference/operator/query/
+package filter
+
+import (
+	"github.com/qiniu/qmgo/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Empty returns the filter which matches all documents
+func Empty() bson.D {
+	return bson.D{}
+}
+
+// field builds {fieldName: {op: value}}
+func field(fieldName, op string, value interface{}) bson.D {
+	return bson.D{{Key: fieldName, Value: bson.D{{Key: op, Value: value}}}}
+}
+
+// Eq matches values that are equal to a specified value
+func Eq(fieldName string, value interface{}) bson.D {
+	return field(fieldName, operator.Eq, value)
+}
+
+// Ne matches all values that are not equal to a specified value
+func Ne(fieldName string, value interface{}) bson.D {
+	return field(fieldName, operator.Ne, value)
+}
+
+// Gt matches values that are greater than a specified value
+func Gt(fieldName string, value interface{}) bson.D {
+	return field(fieldName, operator.Gt, value)
+}
+
+// Gte matches values that are greater than or equal to a specified value
+func Gte(fieldName string, value interface{}) bson.D {
+	return field(fieldName, operator.Gte, value)
+}
+
+// Lt matches values that are less than a specified value
+func Lt(fieldName string, value interface{}) bson.D {
+	return field(fieldName, operator.Lt, value)
+}
+
+// Lte matches values that are less than or equal to a specified value
+func Lte(fieldName string, value interface{}) bson.D {
+	return field(fieldName, operator.Lte, value)
+}
+
+// In matches any of the values specified in an array
+// values of a typed slice can be passed directly: filter.In("age", ages...)
+func In[V any](fieldName string, values ...V) bson.D {
+	return field(fieldName, operator.In, toArray(values))
+}
+
+// Nin matches none of the values specified in an array
+func Nin[V any](fieldName string, values ...V) bson.D {
+	return field(fieldName, operator.Nin, toArray(values))
+}
+
+// And joins filters with a logical AND, no filters returns Empty() which matches all documents
+func And(filters ...bson.D) bson.D {
+	if len(filters) == 0 {
+		return Empty()
+	}
+	return bson.D{{Key: operator.And, Value: toFilterArray(filters)}}
+}
+
+// Or joins filters with a logical OR
+// It panics if no filters are passed, which would match no documents while Empty() matches all
+func Or(filters ...bson.D) bson.D {
+	if len(filters) == 0 {
+		panic("Or: no filters")
+	}
+	return bson.D{{Key: operator.Or, Value: toFilterArray(filters)}}
+}
+
+// Nor joins filters with a logical NOR, no filters returns Empty() which matches all documents
+func Nor(filters ...bson.D) bson.D {
+	if len(filters) == 0 {
+		return Empty()
+	}
+	return bson.D{{Key: operator.Nor, Value: toFilterArray(filters)}}
+}
+
+// Not inverts the effect of every field condition in f
+// Example: filter.Not(filter.Gt("age", 7)) builds {"age": {"$not": {"$gt": 7}}}
+// The conditions in f must be operator expressions, like the ones built by this package
+func Not(f bson.D) bson.D {
+	res := make(bson.D, 0, len(f))
+	for _, e := range f {
+		res = append(res, bson.E{Key: e.Key, Value: bson.D{{Key: operator.Not, Value: e.Value}}})
+	}
+	return res
+}
+
+// Exists matches documents that have (or don't have) the specified field
+func Exists(fieldName string, exists bool) bson.D {
+	return field(fieldName, operator.Exists, exists)
+}
+
+// Type selects documents if a field is of the specified type
+// The t can be BSON type number or alias, like 2 or "string"
+func Type(fieldName string, t interface{}) bson.D {
+	return field(fieldName, operator.Type, t)
+}
+
+// Regex selects documents where values match a specified regular expression
+// The options can be empty, reference: https://docs.mongodb.com/manual/reference/operator/query/regex/#op._S_options
+func Regex(fieldName, pattern, options string) bson.D {
+	cond := bson.D{{Key: operator.Regex, Value: pattern}}
+	if options != "" {
+		cond = append(cond, bson.E{Key: "$options", Value: options})
+	}
+	return bson.D{{Key: fieldName, Value: cond}}
+}
+
+// Mod performs a modulo operation on the value of a field and selects documents with a specified result
+func Mod(fieldName string, divisor, remainder int64) bson.D {
+	return field(fieldName, operator.Mod, bson.A{divisor, remainder})
+}
+
+// Expr allows use of aggregation expressions within the query language
+func Expr(expression interface{}) bson.D {
+	return bson.D{{Key: operator.Expr, Value: expression}}
+}
+
+// Text performs text search, the collection must have a text index
+func Text(search string) bson.D {
+	return bson.D{{Key: operator.Text, Value: bson.D{{Key: "$search", Value: search}}}}
+}
+
+// All matches arrays that contain all elements specified in the query
+func All[V any](fieldName string, values ...V) bson.D {
+	return field(fieldName, operator.All, toArray(values))
+}
+
+// ElemMatch selects documents if element in the array field matches all the specified filters
+// For array of documents: filter.ElemMatch("results", filter.Eq("product", "xyz"), filter.Gte("score", 8))
+// For array of scalars, use an empty field name: filter.ElemMatch("scores", filter.Gte("", 80))
+func ElemMatch(fieldName string, filters ...bson.D) bson.D {
+	cond := bson.D{}
+	for _, f := range filters {
+		for _, e := range f {
+			if e.Key == "" {
+				// condition on the element itself, like {"$gte": 80}
+				if d, ok := e.Value.(bson.D); ok {
+					cond = append(cond, d...)
+					continue
+				}
+			}
+			cond = append(cond, e)
+		}
+	}
+	return field(fieldName, operator.ElemMatch, cond)
+}
+
+// Size selects documents if the array field is a specified size
+func Size(fieldName string, size int) bson.D {
+	return field(fieldName, operator.Size, size)
+}
+
+// toArray converts the typed values to bson.A
+func toArray[V any](values []V) bson.A {
+	res := make(bson.A, 0, len(values))
+	for _, v := range values {
+		res = append(res, v)
+	}
+	return res
+}
+
+// toFilterArray converts the filters to bson.A
+func toFilterArray(filters []bson.D) bson.A {
+	res := make(bson.A, 0, len(filters))
+	for _, f := range filters {
+		res = append(res, f)
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestComparison(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal(bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "Lucas"}}}}, Eq("name", "Lucas"))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$ne", Value: 7}}}}, Ne("age", 7))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 7}}}}, Gt("age", 7))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$gte", Value: 7}}}}, Gte("age", 7))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$lt", Value: 7}}}}, Lt("age", 7))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$lte", Value: 7}}}}, Lte("age", 7))
+
+	ages := []int{1, 2}
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$in", Value: bson.A{1, 2}}}}}, In("age", ages...))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$nin", Value: bson.A{"a"}}}}}, Nin("age", "a"))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}, In[int]("age"))
+}
+
+func TestLogical(t *testing.T) {
+	ast := require.New(t)
+
+	f := And(Eq("name", "Lucas"), Or(Gt("age", 7), Exists("weight", false)))
+	expected := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "name", Value: bson.D{{Key: "$eq", Value: "Lucas"}}}},
+		bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 7}}}},
+			bson.D{{Key: "weight", Value: bson.D{{Key: "$exists", Value: false}}}},
+		}}},
+	}}}
+	ast.Equal(expected, f)
+
+	ast.Equal(bson.D{{Key: "$nor", Value: bson.A{Eq("a", 1)}}}, Nor(Eq("a", 1)))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 7}}}}}}, Not(Gt("age", 7)))
+	ast.Equal(bson.D{}, Empty())
+
+	// no filters
+	ast.Equal(bson.D{}, And())
+	ast.Equal(bson.D{}, Nor())
+	ast.PanicsWithValue("Or: no filters", func() { Or() })
+	var filters []bson.D
+	ast.Equal(bson.D{}, And(filters...))
+}
+
+func TestElementAndEvaluation(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal(bson.D{{Key: "name", Value: bson.D{{Key: "$type", Value: "string"}}}}, Type("name", "string"))
+	ast.Equal(bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^Lu"}}}}, Regex("name", "^Lu", ""))
+	ast.Equal(bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^lu"}, {Key: "$options", Value: "i"}}}}, Regex("name", "^lu", "i"))
+	ast.Equal(bson.D{{Key: "age", Value: bson.D{{Key: "$mod", Value: bson.A{int64(4), int64(0)}}}}}, Mod("age", 4, 0))
+	ast.Equal(bson.D{{Key: "$expr", Value: bson.M{"$gt": bson.A{"$a", "$b"}}}}, Expr(bson.M{"$gt": bson.A{"$a", "$b"}}))
+	ast.Equal(bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "coffee"}}}}, Text("coffee"))
+}
+
+func TestArray(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal(bson.D{{Key: "tags", Value: bson.D{{Key: "$all", Value: bson.A{"a", "b"}}}}}, All("tags", "a", "b"))
+	ast.Equal(bson.D{{Key: "tags", Value: bson.D{{Key: "$size", Value: 2}}}}, Size("tags", 2))
+
+	expected := bson.D{{Key: "results", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "product", Value: bson.D{{Key: "$eq", Value: "xyz"}}},
+		{Key: "score", Value: bson.D{{Key: "$gte", Value: 8}}},
+	}}}}}
+	ast.Equal(expected, ElemMatch("results", Eq("product", "xyz"), Gte("score", 8)))
+
+	expected = bson.D{{Key: "scores", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "$gte", Value: 80},
+		{Key: "$lt", Value: 85},
+	}}}}}
+	ast.Equal(expected, ElemMatch("scores", Gte("", 80), Lt("", 85)))
+}
@@ -0,0 +1,200 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/qiniu/qmgo/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Func is the hook function
+// The doc is the document of operation, like the element of docs in InsertMany,
+// or the hook in options if there is no document, like UpdateHook, it is nil if neither is set.
+type Func func(ctx context.Context, doc interface{}) error
+
+// Hooks holds the hook functions by operation type, it is safe for concurrent use
+type Hooks struct {
+	mu    sync.RWMutex
+	funcs map[operator.OpType][]Func
+}
+
+// NewHooks creates the empty Hooks
+func NewHooks() *Hooks {
+	return &Hooks{funcs: make(map[operator.OpType][]Func)}
+}
+
+var (
+	typeHooksMu sync.Mutex
+	typeHooks   = make(map[reflect.Type]*Hooks)
+)
+
+// For returns the global Hooks of the type of model, they are called for every document of the type,
+// and for the operations without document on the Collection whose Model is the type,
+// model can be either the struct or the pointer to struct
+func For(model interface{}) *Hooks {
+	t := indirectType(reflect.TypeOf(model))
+	typeHooksMu.Lock()
+	defer typeHooksMu.Unlock()
+	h, ok := typeHooks[t]
+	if !ok {
+		h = NewHooks()
+		typeHooks[t] = h
+	}
+	return h
+}
+
+// Register registers fn called on opType
+func (h *Hooks) Register(opType operator.OpType, fn Func) *Hooks {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.funcs[opType] = append(h.funcs[opType], fn)
+	return h
+}
+
+// BeforeInsert registers fn called before insert
+func (h *Hooks) BeforeInsert(fn Func) *Hooks {
+	return h.Register(operator.BeforeInsert, fn)
+}
+
+// AfterInsert registers fn called after insert
+func (h *Hooks) AfterInsert(fn Func) *Hooks {
+	return h.Register(operator.AfterInsert, fn)
+}
+
+// BeforeUpdate registers fn called before update
+func (h *Hooks) BeforeUpdate(fn Func) *Hooks {
+	return h.Register(operator.BeforeUpdate, fn)
+}
+
+// AfterUpdate registers fn called after update
+func (h *Hooks) AfterUpdate(fn Func) *Hooks {
+	return h.Register(operator.AfterUpdate, fn)
+}
+
+// BeforeReplace registers fn called before replace
+func (h *Hooks) BeforeReplace(fn Func) *Hooks {
+	return h.Register(operator.BeforeReplace, fn)
+}
+
+// AfterReplace registers fn called after replace
+func (h *Hooks) AfterReplace(fn Func) *Hooks {
+	return h.Register(operator.AfterReplace, fn)
+}
+
+// BeforeUpsert registers fn called before upsert
+func (h *Hooks) BeforeUpsert(fn Func) *Hooks {
+	return h.Register(operator.BeforeUpsert, fn)
+}
+
+// AfterUpsert registers fn called after upsert
+func (h *Hooks) AfterUpsert(fn Func) *Hooks {
+	return h.Register(operator.AfterUpsert, fn)
+}
+
+// BeforeRemove registers fn called before remove
+func (h *Hooks) BeforeRemove(fn Func) *Hooks {
+	return h.Register(operator.BeforeRemove, fn)
+}
+
+// AfterRemove registers fn called after remove
+func (h *Hooks) AfterRemove(fn Func) *Hooks {
+	return h.Register(operator.AfterRemove, fn)
+}
+
+// BeforeQuery registers fn called before query
+func (h *Hooks) BeforeQuery(fn Func) *Hooks {
+	return h.Register(operator.BeforeQuery, fn)
+}
+
+// AfterQuery registers fn called after query
+func (h *Hooks) AfterQuery(fn Func) *Hooks {
+	return h.Register(operator.AfterQuery, fn)
+}
+
+// DoFuncs calls the functions registered on opType for every document of doc in order,
+// the global ones registered by For of the type of document first, then the ones in hooks
+// The doc is split into documents if it is a slice or pointer to slice, except bson.D and bson.Raw,
+// if doc is nil, like update without UpdateHook, the global ones of the type of model are called instead.
+// model and hooks can be nil
+func DoFuncs(ctx context.Context, doc, model interface{}, opType operator.OpType, hooks *Hooks) error {
+	return eachDoc(doc, func(doc interface{}) error {
+		typeOf := doc
+		if doc == nil {
+			typeOf = model
+		}
+		if err := typeHooksOf(typeOf).do(ctx, doc, opType); err != nil {
+			return err
+		}
+		return hooks.do(ctx, doc, opType)
+	})
+}
+
+// do calls the functions registered on opType in order, it returns the first error
+func (h *Hooks) do(ctx context.Context, doc interface{}, opType operator.OpType) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	funcs := h.funcs[opType]
+	h.mu.RUnlock()
+	for _, fn := range funcs {
+		if err := fn(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeHooksOf returns the global Hooks of the type of doc, or nil if not registered
+func typeHooksOf(doc interface{}) *Hooks {
+	t := reflect.TypeOf(doc)
+	if t == nil {
+		return nil
+	}
+	typeHooksMu.Lock()
+	defer typeHooksMu.Unlock()
+	return typeHooks[indirectType(t)]
+}
+
+// eachDoc calls fn with doc, or every element of doc if it is a slice of documents
+func eachDoc(doc interface{}, fn func(doc interface{}) error) error {
+	v := reflect.ValueOf(doc)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice || v.Type().Elem() == elemType || v.Type().Elem().Kind() == reflect.Uint8 {
+		return fn(doc)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := fn(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// elemType is the type of element of bson.D
+var elemType = reflect.TypeOf(bson.E{})
+
+// indirectType returns the type pointed by t
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
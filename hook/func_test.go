@@ -0,0 +1,101 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/qiniu/qmgo/operator"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type funcUser struct {
+	Name   string
+	Tenant string
+}
+
+func TestDoFuncs(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+
+	var calls []string
+	hooks := NewHooks().
+		BeforeInsert(func(ctx context.Context, doc interface{}) error {
+			doc.(*funcUser).Tenant = "t1"
+			calls = append(calls, "coll")
+			return nil
+		}).
+		AfterRemove(func(ctx context.Context, doc interface{}) error {
+			ast.Nil(doc)
+			calls = append(calls, "remove")
+			return nil
+		})
+	For(funcUser{}).BeforeInsert(func(ctx context.Context, doc interface{}) error {
+		calls = append(calls, "type")
+		return nil
+	})
+	defer delete(typeHooks, reflect.TypeOf(funcUser{}))
+
+	u := &funcUser{Name: "Lucas"}
+	ast.NoError(DoFuncs(ctx, u, nil, operator.BeforeInsert, hooks))
+	ast.Equal("t1", u.Tenant)
+	ast.Equal([]string{"type", "coll"}, calls)
+
+	// every element of slice
+	calls = nil
+	us := []*funcUser{{Name: "a"}, {Name: "b"}}
+	ast.NoError(DoFuncs(ctx, &us, nil, operator.BeforeInsert, hooks))
+	ast.Equal([]string{"type", "coll", "type", "coll"}, calls)
+	ast.Equal("t1", us[1].Tenant)
+
+	// no document
+	calls = nil
+	ast.NoError(DoFuncs(ctx, nil, nil, operator.AfterRemove, hooks))
+	ast.NoError(DoFuncs(ctx, nil, nil, operator.AfterRemove, nil))
+	ast.Equal([]string{"remove"}, calls)
+
+	// the type of model is used if no document
+	calls = nil
+	For(funcUser{}).AfterRemove(func(ctx context.Context, doc interface{}) error {
+		ast.Nil(doc)
+		calls = append(calls, "model")
+		return nil
+	})
+	ast.NoError(DoFuncs(ctx, nil, &funcUser{}, operator.AfterRemove, hooks))
+	ast.Equal([]string{"model", "remove"}, calls)
+
+	// bson.D is one document
+	n := 0
+	bsonHooks := NewHooks().BeforeInsert(func(ctx context.Context, doc interface{}) error {
+		n++
+		_, ok := doc.(bson.D)
+		ast.True(ok)
+		return nil
+	})
+	ast.NoError(DoFuncs(ctx, bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}, nil, operator.BeforeInsert, bsonHooks))
+	ast.Equal(1, n)
+
+	// error stops
+	errHooks := NewHooks().BeforeUpdate(func(ctx context.Context, doc interface{}) error {
+		return errors.New("fail")
+	}).BeforeUpdate(func(ctx context.Context, doc interface{}) error {
+		ast.Fail("should not be called")
+		return nil
+	})
+	ast.EqualError(DoFuncs(ctx, u, nil, operator.BeforeUpdate, errHooks), "fail")
+}
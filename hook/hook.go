@@ -120,18 +120,36 @@ type AfterUpdateHook interface {
 	AfterUpdate(ctx context.Context) error
 }
 
-// beforeUpdate calls custom BeforeUpdate
+// BeforeUpdateOpHook defines the Update hook interface receiving the Operation, it works for replace too
+type BeforeUpdateOpHook interface {
+	BeforeUpdateOp(ctx context.Context, op *Operation) error
+}
+type AfterUpdateOpHook interface {
+	AfterUpdateOp(ctx context.Context, op *Operation) error
+}
+
+// beforeUpdate calls custom BeforeUpdate and BeforeUpdateOp
 func beforeUpdate(ctx context.Context, hook interface{}) error {
 	if ih, ok := hook.(BeforeUpdateHook); ok {
-		return ih.BeforeUpdate(ctx)
+		if err := ih.BeforeUpdate(ctx); err != nil {
+			return err
+		}
+	}
+	if ih, ok := hook.(BeforeUpdateOpHook); ok {
+		return ih.BeforeUpdateOp(ctx, operationOf(ctx))
 	}
 	return nil
 }
 
-// afterUpdate calls custom AfterUpdate
+// afterUpdate calls custom AfterUpdate and AfterUpdateOp
 func afterUpdate(ctx context.Context, hook interface{}) error {
 	if ih, ok := hook.(AfterUpdateHook); ok {
-		return ih.AfterUpdate(ctx)
+		if err := ih.AfterUpdate(ctx); err != nil {
+			return err
+		}
+	}
+	if ih, ok := hook.(AfterUpdateOpHook); ok {
+		return ih.AfterUpdateOp(ctx, operationOf(ctx))
 	}
 	return nil
 }
@@ -144,18 +162,37 @@ type AfterQueryHook interface {
 	AfterQuery(ctx context.Context) error
 }
 
-// beforeQuery calls custom BeforeQuery
+// BeforeQueryOpHook defines the query hook interface receiving the Operation,
+// the Filter, Sort, Projection and Limit of op can be changed to change the query
+type BeforeQueryOpHook interface {
+	BeforeQueryOp(ctx context.Context, op *Operation) error
+}
+type AfterQueryOpHook interface {
+	AfterQueryOp(ctx context.Context, op *Operation) error
+}
+
+// beforeQuery calls custom BeforeQuery and BeforeQueryOp
 func beforeQuery(ctx context.Context, hook interface{}) error {
 	if ih, ok := hook.(BeforeQueryHook); ok {
-		return ih.BeforeQuery(ctx)
+		if err := ih.BeforeQuery(ctx); err != nil {
+			return err
+		}
+	}
+	if ih, ok := hook.(BeforeQueryOpHook); ok {
+		return ih.BeforeQueryOp(ctx, operationOf(ctx))
 	}
 	return nil
 }
 
-// afterQuery calls custom AfterQuery
+// afterQuery calls custom AfterQuery and AfterQueryOp
 func afterQuery(ctx context.Context, hook interface{}) error {
 	if ih, ok := hook.(AfterQueryHook); ok {
-		return ih.AfterQuery(ctx)
+		if err := ih.AfterQuery(ctx); err != nil {
+			return err
+		}
+	}
+	if ih, ok := hook.(AfterQueryOpHook); ok {
+		return ih.AfterQueryOp(ctx, operationOf(ctx))
 	}
 	return nil
 }
@@ -168,18 +205,36 @@ type AfterRemoveHook interface {
 	AfterRemove(ctx context.Context) error
 }
 
-// beforeRemove calls custom BeforeRemove
+// BeforeRemoveOpHook defines the remove hook interface receiving the Operation
+type BeforeRemoveOpHook interface {
+	BeforeRemoveOp(ctx context.Context, op *Operation) error
+}
+type AfterRemoveOpHook interface {
+	AfterRemoveOp(ctx context.Context, op *Operation) error
+}
+
+// beforeRemove calls custom BeforeRemove and BeforeRemoveOp
 func beforeRemove(ctx context.Context, hook interface{}) error {
 	if ih, ok := hook.(BeforeRemoveHook); ok {
-		return ih.BeforeRemove(ctx)
+		if err := ih.BeforeRemove(ctx); err != nil {
+			return err
+		}
+	}
+	if ih, ok := hook.(BeforeRemoveOpHook); ok {
+		return ih.BeforeRemoveOp(ctx, operationOf(ctx))
 	}
 	return nil
 }
 
-// afterRemove calls custom AfterRemove
+// afterRemove calls custom AfterRemove and AfterRemoveOp
 func afterRemove(ctx context.Context, hook interface{}) error {
 	if ih, ok := hook.(AfterRemoveHook); ok {
-		return ih.AfterRemove(ctx)
+		if err := ih.AfterRemove(ctx); err != nil {
+			return err
+		}
+	}
+	if ih, ok := hook.(AfterRemoveOpHook); ok {
+		return ih.AfterRemoveOp(ctx, operationOf(ctx))
 	}
 	return nil
 }
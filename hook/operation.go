@@ -0,0 +1,67 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Operation describes the update, replace, remove and query operations of Collection for hooks
+// It is passed to the hooks implementing BeforeUpdateOpHook and the like, and can be got by
+// OperationFromContext in other hooks and middlewares
+type Operation struct {
+	// Collection is the name of collection
+	Collection string
+	// Filter, Sort, Projection and Limit can be changed by the before query hooks to change the query
+	Filter     interface{}
+	Sort       interface{}
+	Projection interface{}
+	Limit      *int64
+	// Update is the update document, or the replacement of replace operation, nil for remove operation
+	Update interface{}
+	// Options is the options passed to driver, like *options.UpdateOptions and *options.DeleteOptions
+	Options interface{}
+
+	// Result is the result argument of query, like the pointer passed to Query.All, only set in after query hooks
+	Result interface{}
+
+	// UpdateResult is the result of update and replace operations, only set in After hooks
+	UpdateResult *mongo.UpdateResult
+	// DeleteResult is the result of remove operations, only set in After hooks
+	DeleteResult *mongo.DeleteResult
+}
+
+// operationKey is the key of Operation in context
+type operationKey struct{}
+
+// WithOperation returns the copy of ctx carrying op
+func WithOperation(ctx context.Context, op *Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// OperationFromContext returns the Operation carried by ctx, or nil if there is none
+func OperationFromContext(ctx context.Context) *Operation {
+	op, _ := ctx.Value(operationKey{}).(*Operation)
+	return op
+}
+
+// operationOf returns the Operation carried by ctx, or the empty one if there is none
+func operationOf(ctx context.Context) *Operation {
+	if op := OperationFromContext(ctx); op != nil {
+		return op
+	}
+	return &Operation{}
+}
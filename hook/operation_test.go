@@ -0,0 +1,113 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qiniu/qmgo/operator"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type opHook struct {
+	ops    []*Operation
+	legacy int
+	err    error
+}
+
+func (h *opHook) BeforeUpdate(ctx context.Context) error {
+	h.legacy++
+	return h.err
+}
+
+func (h *opHook) BeforeUpdateOp(ctx context.Context, op *Operation) error {
+	h.ops = append(h.ops, op)
+	return nil
+}
+
+func (h *opHook) AfterUpdateOp(ctx context.Context, op *Operation) error {
+	h.ops = append(h.ops, op)
+	return nil
+}
+
+func (h *opHook) BeforeRemoveOp(ctx context.Context, op *Operation) error {
+	h.ops = append(h.ops, op)
+	return nil
+}
+
+func (h *opHook) AfterRemoveOp(ctx context.Context, op *Operation) error {
+	h.ops = append(h.ops, op)
+	return nil
+}
+
+type queryOpHook struct {
+	results []interface{}
+}
+
+func (h *queryOpHook) BeforeQueryOp(ctx context.Context, op *Operation) error {
+	op.Filter = bson.M{"$and": bson.A{op.Filter, bson.M{"tenant": "t1"}}}
+	return nil
+}
+
+func (h *queryOpHook) AfterQueryOp(ctx context.Context, op *Operation) error {
+	h.results = append(h.results, op.Result)
+	return nil
+}
+
+func TestOperationHook(t *testing.T) {
+	ast := require.New(t)
+
+	ctx := context.Background()
+	ast.Nil(OperationFromContext(ctx))
+
+	op := &Operation{Collection: "user", Filter: bson.M{"name": "Lucas"}, Update: bson.M{"$set": bson.M{"age": 7}}}
+	ctx = WithOperation(ctx, op)
+	ast.Same(op, OperationFromContext(ctx))
+
+	h := &opHook{}
+	ast.NoError(Do(ctx, h, operator.BeforeUpdate))
+	ast.NoError(Do(ctx, h, operator.BeforeReplace))
+	op.UpdateResult = &mongo.UpdateResult{MatchedCount: 1}
+	ast.NoError(Do(ctx, h, operator.AfterUpdate))
+	ast.Equal(2, h.legacy)
+	ast.Len(h.ops, 3)
+	ast.Same(op, h.ops[0])
+	ast.Equal(int64(1), h.ops[2].UpdateResult.MatchedCount)
+
+	// no operation in ctx
+	h = &opHook{}
+	ast.NoError(Do(context.Background(), h, operator.BeforeRemove))
+	ast.NoError(Do(context.Background(), h, operator.AfterRemove))
+	ast.Len(h.ops, 2)
+	ast.Equal(&Operation{}, h.ops[0])
+
+	// the error of legacy hook stops
+	h = &opHook{err: errors.New("fail")}
+	ast.EqualError(Do(ctx, h, operator.BeforeUpdate), "fail")
+	ast.Len(h.ops, 0)
+
+	// query hooks change the filter and observe the result
+	qh := &queryOpHook{}
+	op = &Operation{Collection: "user", Filter: bson.M{"name": "Lucas"}}
+	ctx = WithOperation(context.Background(), op)
+	ast.NoError(Do(ctx, qh, operator.BeforeQuery))
+	ast.Equal(bson.M{"$and": bson.A{bson.M{"name": "Lucas"}, bson.M{"tenant": "t1"}}}, op.Filter)
+	op.Result = int64(3)
+	ast.NoError(Do(ctx, qh, operator.AfterQuery))
+	ast.Equal([]interface{}{int64(3)}, qh.results)
+}
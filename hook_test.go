@@ -18,6 +18,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/qiniu/qmgo/hook"
 	"github.com/qiniu/qmgo/operator"
 	"github.com/qiniu/qmgo/options"
 	"github.com/stretchr/testify/require"
@@ -567,3 +568,202 @@ func TestHookErr(t *testing.T) {
 	ast.Equal(2, myReplaceHook.beforeUCount)
 	ast.Equal(1, myReplaceHook.afterUCount)
 }
+
+type tenantUser struct {
+	Name   string `bson:"name"`
+	Tenant string `bson:"tenant"`
+}
+
+func TestCollectionHooks(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	var calls []string
+	cli.Hooks().
+		BeforeInsert(func(ctx context.Context, doc interface{}) error {
+			if u, ok := doc.(*tenantUser); ok {
+				u.Tenant = "t1"
+			}
+			return nil
+		}).
+		BeforeUpdate(func(ctx context.Context, doc interface{}) error {
+			calls = append(calls, "beforeUpdate")
+			return nil
+		}).
+		AfterRemove(func(ctx context.Context, doc interface{}) error {
+			calls = append(calls, "afterRemove")
+			return nil
+		}).
+		BeforeQuery(func(ctx context.Context, doc interface{}) error {
+			calls = append(calls, "beforeQuery")
+			return nil
+		})
+	hook.For(&tenantUser{}).AfterInsert(func(ctx context.Context, doc interface{}) error {
+		calls = append(calls, "afterInsert:"+doc.(*tenantUser).Name)
+		return nil
+	})
+
+	_, err := cli.InsertMany(ctx, []*tenantUser{{Name: "Lucas"}, {Name: "Alice"}})
+	ast.NoError(err)
+	ast.NoError(cli.UpdateOne(ctx, bson.M{"name": "Lucas"}, bson.M{operator.Set: bson.M{"name": "Joe"}}))
+	ast.NoError(cli.Remove(ctx, bson.M{"name": "Joe"}))
+	_, err = cli.Bulk().InsertOne(&tenantUser{Name: "Jess"}).Run(ctx)
+	ast.NoError(err)
+
+	var res []tenantUser
+	ast.NoError(cli.Find(ctx, bson.M{"tenant": "t1"}).All(&res))
+	ast.Len(res, 2)
+	ast.Equal([]string{"afterInsert:Lucas", "afterInsert:Alice", "beforeUpdate", "afterRemove", "afterInsert:Jess", "beforeQuery"}, calls)
+
+	// the other collection has its own hooks
+	other := cli.Database.Collection("test_other_hooks")
+	defer other.DropCollection(ctx)
+	u := &tenantUser{Name: "Bob"}
+	_, err = other.InsertOne(ctx, u)
+	ast.NoError(err)
+	ast.Empty(u.Tenant)
+
+	// the same collection got again shares the hooks
+	same := cli.Database.Collection("test")
+	u = &tenantUser{Name: "Bob"}
+	_, err = same.InsertOne(ctx, u)
+	ast.NoError(err)
+	ast.Equal("t1", u.Tenant)
+
+	// the hooks of model type are called for the operation without document
+	var removed int
+	hook.For(&tenantUser{}).AfterRemove(func(ctx context.Context, doc interface{}) error {
+		removed++
+		return nil
+	})
+	modelColl := cli.Database.Collection("test_model_hooks", &options.CollectionOptions{Model: &tenantUser{}})
+	defer modelColl.DropCollection(ctx)
+	_, err = modelColl.InsertOne(ctx, &tenantUser{Name: "Bob"})
+	ast.NoError(err)
+	ast.NoError(modelColl.Remove(ctx, bson.M{"name": "Bob"}))
+	ast.Equal(1, removed)
+}
+
+type auditHook struct {
+	ops []hook.Operation
+}
+
+func (a *auditHook) BeforeUpdateOp(ctx context.Context, op *hook.Operation) error {
+	if op.Filter == nil {
+		return errors.New("filter is required")
+	}
+	a.ops = append(a.ops, *op)
+	return nil
+}
+
+func (a *auditHook) AfterUpdateOp(ctx context.Context, op *hook.Operation) error {
+	a.ops = append(a.ops, *op)
+	return nil
+}
+
+func (a *auditHook) AfterRemoveOp(ctx context.Context, op *hook.Operation) error {
+	a.ops = append(a.ops, *op)
+	return nil
+}
+
+func TestOperationHook(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	_, err := cli.InsertMany(ctx, []UserHook{{Name: "Lucas", Age: 7}, {Name: "Alice", Age: 7}})
+	ast.NoError(err)
+
+	a := &auditHook{}
+	filter := bson.M{"name": "Lucas"}
+	update := bson.M{operator.Set: bson.M{"age": 8}}
+	ast.NoError(cli.UpdateOne(ctx, filter, update, options.UpdateOptions{UpdateHook: a}))
+	ast.Len(a.ops, 2)
+	ast.Equal("test", a.ops[0].Collection)
+	ast.Equal(filter, a.ops[0].Filter)
+	ast.Equal(update, a.ops[0].Update)
+	ast.NotNil(a.ops[0].Options)
+	ast.Nil(a.ops[0].UpdateResult)
+	ast.Equal(int64(1), a.ops[1].UpdateResult.ModifiedCount)
+
+	ast.Error(cli.UpdateOne(ctx, nil, update, options.UpdateOptions{UpdateHook: a}))
+
+	a.ops = nil
+	_, err = cli.RemoveAll(ctx, bson.M{"age": 7}, options.RemoveOptions{RemoveHook: a})
+	ast.NoError(err)
+	ast.Len(a.ops, 1)
+	ast.Equal(int64(1), a.ops[0].DeleteResult.DeletedCount)
+
+	// function hooks get the operation from ctx
+	var deleted int64
+	cli.Hooks().AfterRemove(func(ctx context.Context, doc interface{}) error {
+		deleted += hook.OperationFromContext(ctx).DeleteResult.DeletedCount
+		return nil
+	})
+	ast.NoError(cli.Remove(ctx, bson.M{"name": "Lucas"}))
+	ast.Equal(int64(1), deleted)
+}
+
+func TestQueryOperationHook(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	_, err := cli.InsertMany(ctx, []tenantUser{{Name: "Lucas", Tenant: "t1"}, {Name: "Alice", Tenant: "t1"}, {Name: "Bob", Tenant: "t2"}})
+	ast.NoError(err)
+
+	var results []interface{}
+	cli.Hooks().
+		BeforeQuery(func(ctx context.Context, doc interface{}) error {
+			op := hook.OperationFromContext(ctx)
+			op.Filter = bson.M{"$and": bson.A{op.Filter, bson.M{"tenant": "t1"}}}
+			return nil
+		}).
+		AfterQuery(func(ctx context.Context, doc interface{}) error {
+			results = append(results, hook.OperationFromContext(ctx).Result)
+			return nil
+		})
+
+	var res []tenantUser
+	ast.NoError(cli.Find(ctx, bson.M{}).Sort("name").All(&res))
+	ast.Equal([]tenantUser{{Name: "Alice", Tenant: "t1"}, {Name: "Lucas", Tenant: "t1"}}, res)
+	ast.Equal([]interface{}{&res}, results)
+
+	one := tenantUser{}
+	ast.Equal(ErrNoSuchDocuments, cli.Find(ctx, bson.M{"name": "Bob"}).One(&one))
+
+	n, err := cli.Find(ctx, bson.M{}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(2), n)
+	ast.Equal(int64(2), results[len(results)-1])
+
+	var names []string
+	ast.NoError(cli.Find(ctx, bson.M{}).Distinct("name", &names))
+	ast.ElementsMatch([]string{"Lucas", "Alice"}, names)
+
+	cursor := cli.Find(ctx, bson.M{}).Cursor()
+	ast.NoError(cursor.Err())
+	res = nil
+	ast.NoError(cursor.All(&res))
+	ast.Len(res, 2)
+
+	ast.Equal(ErrNoSuchDocuments, cli.Find(ctx, bson.M{"name": "Bob"}).Apply(Change{
+		Update:    bson.M{operator.Set: bson.M{"name": "Joe"}},
+		ReturnNew: true,
+	}, &one))
+
+	// the error of before query hook stops the query
+	cli.Hooks().BeforeQuery(func(ctx context.Context, doc interface{}) error {
+		return errors.New("denied")
+	})
+	ast.EqualError(cli.Find(ctx, bson.M{}).Cursor().Err(), "denied")
+	_, err = cli.Find(ctx, bson.M{}).Count()
+	ast.EqualError(err, "denied")
+}
@@ -0,0 +1,528 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/qmgo/field"
+	opts "github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexTagName is the struct tag used to declare indexes on the model
+const indexTagName = "qmgo"
+
+// IndexSyncResult is the result type returned by Collection.SyncIndexesFor
+type IndexSyncResult struct {
+	// The names of indexes created.
+	Created []string
+	// The names of indexes which exist on the server but are not declared in the model.
+	Unused []string
+	// The names of unused indexes dropped, only when SyncIndexesOptions.DropUnused is true.
+	Dropped []string
+	// The names of declared indexes which conflict with the existing ones of same name, they are left untouched.
+	Conflicts []string
+}
+
+// IndexKey is one key of the index
+type IndexKey struct {
+	Field string
+	// Direction is 1 for ascending and -1 for descending, 0 for special index types
+	Direction int32
+	// Type is the special index type like "text", "2dsphere" and "hashed", empty for ascending/descending index
+	Type string
+}
+
+// IndexSpec describes an index existing on the collection
+type IndexSpec struct {
+	Name                    string
+	Keys                    []IndexKey
+	Unique                  bool
+	Sparse                  bool
+	ExpireAfterSeconds      *int32 // nil if not a TTL index
+	PartialFilterExpression bson.M
+	Collation               *options.Collation
+	Version                 int32
+}
+
+// Key returns the keys in the format of opts.IndexModel.Key, like []string{"name", "-age"}
+// The special index types are returned as "field_type", like "content_text"
+func (s IndexSpec) Key() []string {
+	keys := make([]string, 0, len(s.Keys))
+	for _, k := range s.Keys {
+		switch {
+		case k.Type != "":
+			keys = append(keys, k.Field+"_"+k.Type)
+		case k.Direction < 0:
+			keys = append(keys, "-"+k.Field)
+		default:
+			keys = append(keys, k.Field)
+		}
+	}
+	return keys
+}
+
+// IndexConflict describes a desired index which has the same name as an existing index but different definition,
+// or has the same keys as an existing index of another name
+type IndexConflict struct {
+	Name     string
+	Existing IndexSpec
+	Desired  opts.IndexModel
+	Reason   string
+}
+
+// IndexDiff is the result type returned by Collection.DiffIndexes
+type IndexDiff struct {
+	// The desired indexes which don't exist.
+	ToCreate []opts.IndexModel
+	// The existing indexes which are not desired, the index on _id is never included.
+	ToDrop []IndexSpec
+	// The desired indexes whose name exists but the definition is different, or whose keys are indexed by
+	// another name.
+	Conflicts []IndexConflict
+}
+
+// IndexModels derives the index models declared by the `qmgo` tags of model
+// The tag consists of directives separated by ";", every directive is kind[:keys][=seconds]:
+//   - kind is one of index, unique and ttl
+//   - keys is the comma-joined index keys, prefix name with dash (-) for descending order,
+//     if keys is omitted, the bson name of the tagged field is used
+//   - seconds is the expireAfterSeconds of ttl index
+//
+// The bson names follow the driver: only the struct tagged with inline is inlined, the untagged embedded struct
+// is a subdocument and the unexported fields are ignored.
+//
+// Example:
+//
+//	type User struct {
+//	    Name     string    `bson:"name" qmgo:"index"`
+//	    Email    string    `bson:"email" qmgo:"unique"`
+//	    CreateAt time.Time `bson:"createAt" qmgo:"index:-createAt;index:name,-createAt"`
+//	    ExpireAt time.Time `bson:"expireAt" qmgo:"ttl=3600"`
+//	}
+func IndexModels(model interface{}) ([]opts.IndexModel, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ErrNotValidIndexModel
+	}
+	var indexes []opts.IndexModel
+	if err := structIndexModels(t, "", &indexes); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// structIndexModels collects the index models of struct type t, prefix is the bson path of t
+func structIndexModels(t reflect.Type, prefix string, indexes *[]opts.IndexModel) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, inline := field.BsonName(f)
+		if name == "-" {
+			continue
+		}
+		if !inline {
+			name = prefix + name
+		}
+		if tag, ok := f.Tag.Lookup(indexTagName); ok {
+			for _, directive := range strings.Split(tag, ";") {
+				if strings.TrimSpace(directive) == "" {
+					continue
+				}
+				model, err := parseIndexDirective(strings.TrimSpace(directive), name)
+				if err != nil {
+					return err
+				}
+				*indexes = append(*indexes, model)
+			}
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			continue
+		}
+		// the embedded struct without inline tag is a subdocument, the same as the driver encodes it
+		if inline {
+			if err := structIndexModels(ft, prefix, indexes); err != nil {
+				return err
+			}
+		} else if f.Anonymous {
+			if err := structIndexModels(ft, name+".", indexes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseIndexDirective parses one directive of the tag, fieldName is the bson name of the tagged field
+func parseIndexDirective(directive, fieldName string) (opts.IndexModel, error) {
+	var model opts.IndexModel
+	kind, keys := directive, ""
+	if i := strings.Index(directive, ":"); i >= 0 {
+		kind, keys = directive[:i], directive[i+1:]
+	}
+	var seconds string
+	if i := strings.Index(keys, "="); i >= 0 {
+		keys, seconds = keys[:i], keys[i+1:]
+	} else if i := strings.Index(kind, "="); i >= 0 {
+		kind, seconds = kind[:i], kind[i+1:]
+	}
+
+	if keys == "" {
+		model.Key = []string{fieldName}
+	} else {
+		for _, k := range strings.Split(keys, ",") {
+			if key, _ := SplitSortField(strings.TrimSpace(k)); key == "" {
+				return model, fmt.Errorf("%w: empty key in %q", ErrNotValidIndexTag, directive)
+			}
+			model.Key = append(model.Key, strings.TrimSpace(k))
+		}
+	}
+
+	switch kind {
+	case "index":
+	case "unique":
+		model.IndexOptions = options.Index().SetUnique(true)
+	case "ttl":
+		n, err := strconv.ParseInt(seconds, 10, 32)
+		if err != nil || len(model.Key) != 1 {
+			return model, fmt.Errorf("%w: invalid ttl in %q", ErrNotValidIndexTag, directive)
+		}
+		model.IndexOptions = options.Index().SetExpireAfterSeconds(int32(n))
+		return model, nil
+	default:
+		return model, fmt.Errorf("%w: unknown kind in %q", ErrNotValidIndexTag, directive)
+	}
+	if seconds != "" {
+		return model, fmt.Errorf("%w: only ttl index supports seconds in %q", ErrNotValidIndexTag, directive)
+	}
+	return model, nil
+}
+
+// SyncIndexesFor creates the indexes declared by the `qmgo` tags of model if they don't exist,
+// and reports the indexes which exist on the server but are no longer declared.
+// If DropUnused in opts is true, the unused indexes are dropped, the index on _id is never dropped.
+// Declared indexes which conflict with existing ones are reported and left untouched.
+// Reference IndexModels for the tag syntax and DiffIndexes for how indexes are matched
+func (c *Collection) SyncIndexesFor(ctx context.Context, model interface{}, opts ...opts.SyncIndexesOptions) (result *IndexSyncResult, err error) {
+	declared, err := IndexModels(model)
+	if err != nil {
+		return
+	}
+	diff, err := c.DiffIndexes(ctx, declared)
+	if err != nil {
+		return
+	}
+
+	result = &IndexSyncResult{}
+	for _, idx := range diff.ToCreate {
+		if err = c.CreateOneIndex(ctx, idx); err != nil {
+			return
+		}
+		result.Created = append(result.Created, indexName(idx))
+	}
+	for _, conflict := range diff.Conflicts {
+		result.Conflicts = append(result.Conflicts, conflict.Name)
+	}
+	for _, spec := range diff.ToDrop {
+		result.Unused = append(result.Unused, spec.Name)
+	}
+	if len(opts) > 0 && opts[0].DropUnused {
+		for _, name := range result.Unused {
+			if _, err = c.collection.Indexes().DropOne(ctx, name); err != nil {
+				return
+			}
+			result.Dropped = append(result.Dropped, name)
+		}
+	}
+	return
+}
+
+// ListIndexes returns the indexes on the collection
+// If the collection doesn't exist, no index and no error returned
+func (c *Collection) ListIndexes(ctx context.Context) ([]IndexSpec, error) {
+	cursor, err := c.collection.Indexes().List(ctx)
+	if isNamespaceNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raws []rawIndexSpec
+	if err = cursor.All(ctx, &raws); err != nil {
+		return nil, err
+	}
+	specs := make([]IndexSpec, 0, len(raws))
+	for _, raw := range raws {
+		specs = append(specs, raw.toSpec())
+	}
+	return specs, nil
+}
+
+// DiffIndexes compares the desired indexes with the existing ones, nothing is changed on the server
+// Indexes are matched by name, which is IndexOptions.Name if set, otherwise the default name generated
+// by MongoDB like "name_1_age_-1". An index of same name is conflicting if keys, unique, sparse, TTL,
+// partial filter or the collation fields set in the desired index are different.
+// If no index has the name, the desired index is matched by the keys and partial filter, as the server refuses
+// to create it, and it is conflicting with the existing index of another name.
+func (c *Collection) DiffIndexes(ctx context.Context, desired []opts.IndexModel) (*IndexDiff, error) {
+	existing, err := c.ListIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return diffIndexes(existing, desired), nil
+}
+
+// diffIndexes compares the desired indexes with the existing ones, see DiffIndexes
+func diffIndexes(existing []IndexSpec, desired []opts.IndexModel) *IndexDiff {
+	existingByName := make(map[string]IndexSpec, len(existing))
+	for _, spec := range existing {
+		existingByName[spec.Name] = spec
+	}
+
+	diff := &IndexDiff{}
+	desiredNames := make(map[string]bool, len(desired))
+	matched := make(map[string]bool, len(existing))
+	var unnamed []opts.IndexModel
+	for _, idx := range desired {
+		name := indexName(idx)
+		if desiredNames[name] {
+			continue
+		}
+		desiredNames[name] = true
+		spec, ok := existingByName[name]
+		if !ok {
+			unnamed = append(unnamed, idx)
+			continue
+		}
+		matched[name] = true
+		if reason := indexConflict(spec, idx); reason != "" {
+			diff.Conflicts = append(diff.Conflicts, IndexConflict{Name: name, Existing: spec, Desired: idx, Reason: reason})
+		}
+	}
+	for _, idx := range unnamed {
+		spec, ok := sameKeyIndex(existing, matched, idx)
+		if !ok {
+			diff.ToCreate = append(diff.ToCreate, idx)
+			continue
+		}
+		matched[spec.Name] = true
+		reason := fmt.Sprintf("the same keys are indexed by %q", spec.Name)
+		if r := indexConflict(spec, idx); r != "" {
+			reason += ", and " + r
+		}
+		diff.Conflicts = append(diff.Conflicts, IndexConflict{Name: indexName(idx), Existing: spec, Desired: idx, Reason: reason})
+	}
+	for _, spec := range existing {
+		if spec.Name == "_id_" || matched[spec.Name] {
+			continue
+		}
+		diff.ToDrop = append(diff.ToDrop, spec)
+	}
+	return diff
+}
+
+// sameKeyIndex returns the existing index not matched yet which has the same keys and partial filter as idx
+func sameKeyIndex(existing []IndexSpec, matched map[string]bool, idx opts.IndexModel) (IndexSpec, bool) {
+	var partial interface{}
+	if idx.IndexOptions != nil {
+		partial = idx.PartialFilterExpression
+	}
+	for _, spec := range existing {
+		if matched[spec.Name] {
+			continue
+		}
+		if reflect.DeepEqual(spec.Key(), normalizeIndexKey(idx.Key)) && sameDocument(spec.PartialFilterExpression, partial) {
+			return spec, true
+		}
+	}
+	return IndexSpec{}, false
+}
+
+// indexName returns the name of the index model
+func indexName(idx opts.IndexModel) string {
+	if idx.IndexOptions != nil && idx.Name != nil {
+		return *idx.Name
+	}
+	return generateDroppedIndex(idx.Key)
+}
+
+// indexConflict returns the reason why spec and idx are different, empty if they are the same
+func indexConflict(spec IndexSpec, idx opts.IndexModel) string {
+	if !reflect.DeepEqual(spec.Key(), normalizeIndexKey(idx.Key)) {
+		return fmt.Sprintf("keys %v differ from %v", spec.Key(), idx.Key)
+	}
+	o := idx.IndexOptions
+	if o == nil {
+		o = options.Index()
+	}
+	if spec.Unique != (o.Unique != nil && *o.Unique) {
+		return "unique differs"
+	}
+	if spec.Sparse != (o.Sparse != nil && *o.Sparse) {
+		return "sparse differs"
+	}
+	if (spec.ExpireAfterSeconds == nil) != (o.ExpireAfterSeconds == nil) ||
+		(o.ExpireAfterSeconds != nil && *spec.ExpireAfterSeconds != *o.ExpireAfterSeconds) {
+		return "expireAfterSeconds differs"
+	}
+	if !sameDocument(spec.PartialFilterExpression, o.PartialFilterExpression) {
+		return "partialFilterExpression differs"
+	}
+	if o.Collation != nil && !collationMatch(spec.Collation, o.Collation) {
+		return "collation differs"
+	}
+	return ""
+}
+
+// normalizeIndexKey removes the "+" prefix of keys
+func normalizeIndexKey(keys []string) []string {
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		key, n := SplitSortField(k)
+		if n < 0 {
+			key = "-" + key
+		}
+		res = append(res, key)
+	}
+	return res
+}
+
+// sameDocument compares the existing document with the desired one after bson round trip
+func sameDocument(existing bson.M, desired interface{}) bool {
+	if desired == nil {
+		return len(existing) == 0
+	}
+	b, err := bson.Marshal(desired)
+	if err != nil {
+		return false
+	}
+	var d bson.M
+	if err = bson.Unmarshal(b, &d); err != nil {
+		return false
+	}
+	if len(existing) == 0 {
+		return len(d) == 0
+	}
+	eb, err := bson.Marshal(existing)
+	if err != nil {
+		return false
+	}
+	var e bson.M
+	if err = bson.Unmarshal(eb, &e); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(e, d)
+}
+
+// collationMatch checks the fields set in desired collation, the server fills defaults for the others
+func collationMatch(existing, desired *options.Collation) bool {
+	if existing == nil {
+		return false
+	}
+	switch {
+	case desired.Locale != "" && desired.Locale != existing.Locale,
+		desired.Strength != 0 && desired.Strength != existing.Strength,
+		desired.CaseFirst != "" && desired.CaseFirst != existing.CaseFirst,
+		desired.Alternate != "" && desired.Alternate != existing.Alternate,
+		desired.MaxVariable != "" && desired.MaxVariable != existing.MaxVariable,
+		desired.CaseLevel && !existing.CaseLevel,
+		desired.NumericOrdering && !existing.NumericOrdering,
+		desired.Normalization && !existing.Normalization,
+		desired.Backwards && !existing.Backwards:
+		return false
+	}
+	return true
+}
+
+// rawIndexSpec is the index document returned by listIndexes command
+type rawIndexSpec struct {
+	Name                    string `bson:"name"`
+	Key                     bson.D `bson:"key"`
+	Unique                  bool   `bson:"unique"`
+	Sparse                  bool   `bson:"sparse"`
+	ExpireAfterSeconds      *int32 `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.M `bson:"partialFilterExpression"`
+	Collation               bson.M `bson:"collation"`
+	Version                 int32  `bson:"v"`
+}
+
+// toSpec converts the raw index document to IndexSpec
+func (r rawIndexSpec) toSpec() IndexSpec {
+	spec := IndexSpec{
+		Name:                    r.Name,
+		Unique:                  r.Unique,
+		Sparse:                  r.Sparse,
+		ExpireAfterSeconds:      r.ExpireAfterSeconds,
+		PartialFilterExpression: r.PartialFilterExpression,
+		Version:                 r.Version,
+	}
+	for _, e := range r.Key {
+		key := IndexKey{Field: e.Key}
+		switch v := e.Value.(type) {
+		case string:
+			key.Type = v
+		case int32:
+			key.Direction = sign(float64(v))
+		case int64:
+			key.Direction = sign(float64(v))
+		case float64:
+			key.Direction = sign(v)
+		}
+		spec.Keys = append(spec.Keys, key)
+	}
+	if r.Collation != nil {
+		c := &options.Collation{}
+		c.Locale, _ = r.Collation["locale"].(string)
+		c.CaseLevel, _ = r.Collation["caseLevel"].(bool)
+		c.CaseFirst, _ = r.Collation["caseFirst"].(string)
+		if strength, ok := r.Collation["strength"].(int32); ok {
+			c.Strength = int(strength)
+		}
+		c.NumericOrdering, _ = r.Collation["numericOrdering"].(bool)
+		c.Alternate, _ = r.Collation["alternate"].(string)
+		c.MaxVariable, _ = r.Collation["maxVariable"].(string)
+		c.Normalization, _ = r.Collation["normalization"].(bool)
+		c.Backwards, _ = r.Collation["backwards"].(bool)
+		spec.Collation = c
+	}
+	return spec
+}
+
+// sign returns the index direction of n
+func sign(n float64) int32 {
+	if n < 0 {
+		return -1
+	}
+	return 1
+}
+
+// isNamespaceNotFound checks if err is returned because the collection doesn't exist
+func isNamespaceNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 26
+}
@@ -0,0 +1,254 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/options"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	officialOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type IndexedUser struct {
+	field.DefaultField `bson:",inline" qmgo:"index:-createAt"`
+
+	Name     string    `bson:"name" qmgo:"index;index:name,-age"`
+	Age      int       `bson:"age"`
+	Email    string    `bson:"email" qmgo:"unique"`
+	ExpireAt time.Time `bson:"expireAt" qmgo:"ttl=3600"`
+}
+
+func TestIndexModels(t *testing.T) {
+	ast := require.New(t)
+
+	models, err := IndexModels(&IndexedUser{})
+	ast.NoError(err)
+	ast.Len(models, 5)
+	ast.Equal([]string{"-createAt"}, models[0].Key)
+	ast.Nil(models[0].IndexOptions)
+	ast.Equal([]string{"name"}, models[1].Key)
+	ast.Equal([]string{"name", "-age"}, models[2].Key)
+	ast.Equal([]string{"email"}, models[3].Key)
+	ast.True(*models[3].Unique)
+	ast.Equal([]string{"expireAt"}, models[4].Key)
+	ast.Equal(int32(3600), *models[4].ExpireAfterSeconds)
+
+	type Plain struct {
+		Name string
+	}
+	models, err = IndexModels(Plain{})
+	ast.NoError(err)
+	ast.Len(models, 0)
+
+	// only the tagged embedded struct is inlined, the unexported fields are ignored
+	type Embedded struct {
+		Name string `bson:"name" qmgo:"index"`
+	}
+	type Nested struct {
+		Embedded
+		secret string `qmgo:"index"`
+	}
+	models, err = IndexModels(Nested{})
+	ast.NoError(err)
+	ast.Len(models, 1)
+	ast.Equal([]string{"embedded.name"}, models[0].Key)
+
+	_, err = IndexModels(1)
+	ast.Equal(ErrNotValidIndexModel, err)
+
+	type BadKind struct {
+		Name string `qmgo:"primary"`
+	}
+	_, err = IndexModels(BadKind{})
+	ast.True(errors.Is(err, ErrNotValidIndexTag))
+
+	type BadTTL struct {
+		ExpireAt time.Time `qmgo:"ttl:a,b=10"`
+	}
+	_, err = IndexModels(BadTTL{})
+	ast.True(errors.Is(err, ErrNotValidIndexTag))
+
+	type BadSeconds struct {
+		Name string `qmgo:"unique=10"`
+	}
+	_, err = IndexModels(BadSeconds{})
+	ast.True(errors.Is(err, ErrNotValidIndexTag))
+}
+
+func TestDiffIndexes(t *testing.T) {
+	ast := require.New(t)
+
+	existing := []IndexSpec{
+		{Name: "_id_", Keys: []IndexKey{{Field: "_id", Direction: 1}}},
+		{Name: "by_name", Keys: []IndexKey{{Field: "name", Direction: 1}}, Unique: true},
+		{Name: "age_1", Keys: []IndexKey{{Field: "age", Direction: -1}}},
+		{Name: "email_1", Keys: []IndexKey{{Field: "email", Direction: 1}}, PartialFilterExpression: bson.M{"email": bson.M{"$exists": true}}},
+	}
+	diff := diffIndexes(existing, []options.IndexModel{
+		// the same index of another name
+		{Key: []string{"name"}, IndexOptions: officialOpts.Index().SetUnique(true)},
+		// the same name with different keys
+		{Key: []string{"age"}, IndexOptions: officialOpts.Index().SetName("age_1")},
+		// the same keys with different partial filter is another index
+		{Key: []string{"email"}, IndexOptions: officialOpts.Index().SetName("email_all")},
+	})
+	ast.Len(diff.ToCreate, 1)
+	ast.Equal("email_all", *diff.ToCreate[0].Name)
+	ast.Len(diff.Conflicts, 2)
+	ast.Equal("age_1", diff.Conflicts[0].Name)
+	ast.Equal("name_1", diff.Conflicts[1].Name)
+	ast.Equal("by_name", diff.Conflicts[1].Existing.Name)
+	ast.Equal(`the same keys are indexed by "by_name"`, diff.Conflicts[1].Reason)
+	ast.Len(diff.ToDrop, 1)
+	ast.Equal("email_1", diff.ToDrop[0].Name)
+
+	// the options differ too
+	diff = diffIndexes(existing, []options.IndexModel{{Key: []string{"name"}}})
+	ast.Len(diff.ToCreate, 0)
+	ast.Len(diff.Conflicts, 1)
+	ast.Equal(`the same keys are indexed by "by_name", and unique differs`, diff.Conflicts[0].Reason)
+}
+
+func TestCollection_SyncIndexesFor(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	res, err := cli.SyncIndexesFor(ctx, IndexedUser{})
+	ast.NoError(err)
+	sort.Strings(res.Created)
+	ast.Equal([]string{"createAt_-1", "email_1", "expireAt_1", "name_1", "name_1_age_-1"}, res.Created)
+	ast.Len(res.Unused, 0)
+
+	// synced already
+	res, err = cli.SyncIndexesFor(ctx, &IndexedUser{})
+	ast.NoError(err)
+	ast.Len(res.Created, 0)
+
+	ast.NoError(cli.CreateOneIndex(ctx, options.IndexModel{Key: []string{"weight"}, IndexOptions: officialOpts.Index()}))
+	res, err = cli.SyncIndexesFor(ctx, IndexedUser{})
+	ast.NoError(err)
+	ast.Equal([]string{"weight_1"}, res.Unused)
+	ast.Len(res.Dropped, 0)
+
+	res, err = cli.SyncIndexesFor(ctx, IndexedUser{}, options.SyncIndexesOptions{DropUnused: true})
+	ast.NoError(err)
+	ast.Equal([]string{"weight_1"}, res.Dropped)
+
+	res, err = cli.SyncIndexesFor(ctx, IndexedUser{})
+	ast.NoError(err)
+	ast.Len(res.Unused, 0)
+
+	// same name with different definition
+	ast.NoError(cli.DropIndex(ctx, []string{"email"}))
+	ast.NoError(cli.CreateOneIndex(ctx, options.IndexModel{Key: []string{"email"}}))
+	res, err = cli.SyncIndexesFor(ctx, IndexedUser{})
+	ast.NoError(err)
+	ast.Equal([]string{"email_1"}, res.Conflicts)
+	ast.Len(res.Created, 0)
+}
+
+func TestIndexConflict(t *testing.T) {
+	ast := require.New(t)
+
+	ttl := int32(10)
+	spec := rawIndexSpec{
+		Name:               "name_1_age_-1",
+		Key:                bson.D{{Key: "name", Value: int32(1)}, {Key: "age", Value: -1.0}},
+		Unique:             true,
+		ExpireAfterSeconds: &ttl,
+		Collation:          bson.M{"locale": "en", "strength": int32(2), "caseLevel": false},
+	}.toSpec()
+	ast.Equal([]string{"name", "-age"}, spec.Key())
+	ast.Equal("en", spec.Collation.Locale)
+	ast.Equal(2, spec.Collation.Strength)
+
+	idx := options.IndexModel{
+		Key:          []string{"+name", "-age"},
+		IndexOptions: officialOpts.Index().SetUnique(true).SetExpireAfterSeconds(10).SetCollation(&officialOpts.Collation{Locale: "en"}),
+	}
+	ast.Equal("name_1_age_-1", indexName(idx))
+	ast.Equal("", indexConflict(spec, idx))
+
+	idx.IndexOptions = officialOpts.Index().SetUnique(true).SetExpireAfterSeconds(20)
+	ast.Equal("expireAfterSeconds differs", indexConflict(spec, idx))
+	idx.IndexOptions = officialOpts.Index().SetExpireAfterSeconds(10)
+	ast.Equal("unique differs", indexConflict(spec, idx))
+	idx.IndexOptions = officialOpts.Index().SetUnique(true).SetExpireAfterSeconds(10).SetCollation(&officialOpts.Collation{Locale: "fr"})
+	ast.Equal("collation differs", indexConflict(spec, idx))
+	idx.IndexOptions = officialOpts.Index().SetUnique(true).SetExpireAfterSeconds(10).SetPartialFilterExpression(bson.M{"age": bson.M{"$gt": 1}})
+	ast.Equal("partialFilterExpression differs", indexConflict(spec, idx))
+	idx.Key = []string{"name", "age"}
+	ast.NotEqual("", indexConflict(spec, idx))
+
+	text := rawIndexSpec{Name: "content_text", Key: bson.D{{Key: "content", Value: "text"}}}.toSpec()
+	ast.Equal([]string{"content_text"}, text.Key())
+	ast.Equal("text", text.Keys[0].Type)
+
+	named := options.IndexModel{Key: []string{"name"}, IndexOptions: officialOpts.Index().SetName("idx_name")}
+	ast.Equal("idx_name", indexName(named))
+}
+
+func TestCollection_ListIndexes(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	// collection not exists
+	specs, err := cli.ListIndexes(ctx)
+	ast.NoError(err)
+	ast.Len(specs, 0)
+
+	ast.NoError(cli.CreateIndexes(ctx, []options.IndexModel{
+		{Key: []string{"name", "-age"}, IndexOptions: officialOpts.Index().SetUnique(true)},
+		{Key: []string{"expireAt"}, IndexOptions: officialOpts.Index().SetExpireAfterSeconds(100).SetSparse(true)},
+		{Key: []string{"email"}, IndexOptions: officialOpts.Index().SetPartialFilterExpression(bson.M{"age": bson.M{"$gt": 1}})},
+	}))
+	specs, err = cli.ListIndexes(ctx)
+	ast.NoError(err)
+	ast.Len(specs, 4)
+	byName := map[string]IndexSpec{}
+	for _, s := range specs {
+		byName[s.Name] = s
+	}
+	ast.Equal([]string{"name", "-age"}, byName["name_1_age_-1"].Key())
+	ast.True(byName["name_1_age_-1"].Unique)
+	ast.Equal(int32(100), *byName["expireAt_1"].ExpireAfterSeconds)
+	ast.True(byName["expireAt_1"].Sparse)
+	ast.NotNil(byName["email_1"].PartialFilterExpression)
+
+	diff, err := cli.DiffIndexes(ctx, []options.IndexModel{
+		{Key: []string{"name", "-age"}, IndexOptions: officialOpts.Index().SetUnique(true)},
+		{Key: []string{"expireAt"}, IndexOptions: officialOpts.Index().SetExpireAfterSeconds(200).SetSparse(true)},
+		{Key: []string{"weight"}},
+	})
+	ast.NoError(err)
+	ast.Len(diff.ToCreate, 1)
+	ast.Equal([]string{"weight"}, diff.ToCreate[0].Key)
+	ast.Len(diff.ToDrop, 1)
+	ast.Equal("email_1", diff.ToDrop[0].Name)
+	ast.Len(diff.Conflicts, 1)
+	ast.Equal("expireAt_1", diff.Conflicts[0].Name)
+}
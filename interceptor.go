@@ -0,0 +1,59 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/qiniu/qmgo/interceptor"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// intercept runs call as the operation of kind on coll through the registered interceptors
+// call sends the operation with the ctx passed in, and sets the document counts of op
+func intercept(ctx context.Context, coll *mongo.Collection, kind interceptor.Kind, filter interface{},
+	call func(ctx context.Context, op *interceptor.Operation) error) error {
+	op := &interceptor.Operation{
+		Database:   coll.Database().Name(),
+		Collection: coll.Name(),
+		Kind:       kind,
+		Filter:     filter,
+	}
+	return interceptor.Do(ctx, op, func(ctx context.Context) error {
+		return call(ctx, op)
+	})
+}
+
+// setUpdateCounts sets the counts of update result into op
+func setUpdateCounts(op *interceptor.Operation, res *mongo.UpdateResult) {
+	if res == nil {
+		return
+	}
+	op.Matched = res.MatchedCount
+	op.Modified = res.ModifiedCount
+	op.Upserted = res.UpsertedCount
+}
+
+// resultLen returns the length of the slice result points to
+func resultLen(result interface{}) int64 {
+	v := reflect.Indirect(reflect.ValueOf(result))
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice {
+		return 0
+	}
+	return int64(v.Len())
+}
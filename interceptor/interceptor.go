@@ -0,0 +1,106 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package interceptor intercepts every data operation of Collection, Query, Aggregate and Bulk sent to MongoDB
+// It's the base of observability, like tracing by Tracing and metrics by Metrics
+package interceptor
+
+import (
+	"context"
+	"time"
+)
+
+// Kind is the kind of operation, same as the method name of mongo driver
+type Kind string
+
+const (
+	InsertOne              Kind = "insertOne"
+	InsertMany             Kind = "insertMany"
+	UpdateOne              Kind = "updateOne"
+	UpdateMany             Kind = "updateMany"
+	ReplaceOne             Kind = "replaceOne"
+	DeleteOne              Kind = "deleteOne"
+	DeleteMany             Kind = "deleteMany"
+	Find                   Kind = "find"
+	FindOne                Kind = "findOne"
+	CountDocuments         Kind = "countDocuments"
+	EstimatedDocumentCount Kind = "estimatedDocumentCount"
+	Distinct               Kind = "distinct"
+	FindOneAndUpdate       Kind = "findOneAndUpdate"
+	FindOneAndReplace      Kind = "findOneAndReplace"
+	FindOneAndDelete       Kind = "findOneAndDelete"
+	Aggregate              Kind = "aggregate"
+	BulkWrite              Kind = "bulkWrite"
+)
+
+// Operation describes one operation sent to MongoDB
+// The result fields are set after the operation is done, they can be read after calling next in Interceptor
+type Operation struct {
+	Database   string
+	Collection string
+	Kind       Kind
+	// Filter is the filter of query, update and delete, the pipeline of aggregate, nil for insert and bulk write
+	Filter interface{}
+
+	Duration time.Duration
+	Err      error
+	Returned int64 // number of documents returned by query and aggregate, the first batch only for cursor
+	Inserted int64
+	Matched  int64
+	Modified int64
+	Upserted int64
+	Deleted  int64
+}
+
+// Documents returns the number of documents returned, inserted, matched, upserted or deleted by the operation
+func (op *Operation) Documents() int64 {
+	return op.Returned + op.Inserted + op.Matched + op.Upserted + op.Deleted
+}
+
+// Handler sends the operation to MongoDB
+type Handler func(ctx context.Context) error
+
+// Interceptor intercepts the operation, it must call next to continue the operation, and can
+// change the ctx passed to next, like starting a span of tracing
+type Interceptor func(ctx context.Context, op *Operation, next Handler) error
+
+// interceptors the registered interceptors, the first registered is the outermost
+var interceptors []Interceptor
+
+// Register registers interceptor, it should be called before any operation, like in init
+func Register(i Interceptor) {
+	interceptors = append(interceptors, i)
+}
+
+// Do runs the operation by call through the registered interceptors
+// The duration and error of op are set after call returns
+func Do(ctx context.Context, op *Operation, call Handler) error {
+	h := func(ctx context.Context) error {
+		start := time.Now()
+		err := call(ctx)
+		op.Duration = time.Since(start)
+		op.Err = err
+		return err
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		h = chain(interceptors[i], op, h)
+	}
+	return h(ctx)
+}
+
+// chain wraps next with interceptor i
+func chain(i Interceptor, op *Operation, next Handler) Handler {
+	return func(ctx context.Context) error {
+		return i(ctx, op, next)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ctxKey string
+
+func TestDo(t *testing.T) {
+	ast := require.New(t)
+	defer func() { interceptors = nil }()
+	ctx := context.Background()
+
+	// not register
+	op := &Operation{Kind: Find}
+	ast.NoError(Do(ctx, op, func(ctx context.Context) error { return nil }))
+
+	var order []string
+	Register(func(ctx context.Context, op *Operation, next Handler) error {
+		order = append(order, "outer")
+		return next(context.WithValue(ctx, ctxKey("outer"), 1))
+	})
+	Register(func(ctx context.Context, op *Operation, next Handler) error {
+		order = append(order, "inner")
+		err := next(ctx)
+		ast.Equal(errors.New("fail"), op.Err)
+		ast.True(op.Duration >= time.Millisecond)
+		return err
+	})
+	op = &Operation{Kind: Find}
+	err := Do(ctx, op, func(ctx context.Context) error {
+		order = append(order, "call")
+		ast.Equal(1, ctx.Value(ctxKey("outer")))
+		time.Sleep(time.Millisecond)
+		return errors.New("fail")
+	})
+	ast.EqualError(err, "fail")
+	ast.Equal([]string{"outer", "inner", "call"}, order)
+
+	ast.Equal(int64(6), (&Operation{Returned: 1, Inserted: 2, Matched: 3, Modified: 3}).Documents())
+}
+
+type testTracer struct {
+	name  string
+	attrs []Attribute
+	span  *testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	t.name = name
+	t.attrs = attrs
+	t.span = &testSpan{}
+	return context.WithValue(ctx, ctxKey("span"), t.span), t.span
+}
+
+type testSpan struct {
+	attrs []Attribute
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...Attribute) {
+	s.attrs = append(s.attrs, attrs...)
+}
+
+func (s *testSpan) End(err error) {
+	s.err = err
+	s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	ast := require.New(t)
+	defer func() { interceptors = nil }()
+
+	tracer := &testTracer{}
+	Register(Tracing(tracer))
+	op := &Operation{Database: "db", Collection: "user", Kind: UpdateOne}
+	err := Do(context.Background(), op, func(ctx context.Context) error {
+		ast.Equal(tracer.span, ctx.Value(ctxKey("span")))
+		op.Matched = 1
+		return errors.New("fail")
+	})
+	ast.Error(err)
+	ast.Equal("updateOne user", tracer.name)
+	ast.Equal([]Attribute{
+		{Key: AttrDBSystem, Value: "mongodb"},
+		{Key: AttrDBName, Value: "db"},
+		{Key: AttrDBOperation, Value: "updateOne"},
+		{Key: AttrDBCollection, Value: "user"},
+	}, tracer.attrs)
+	ast.Equal([]Attribute{{Key: AttrDocuments, Value: int64(1)}}, tracer.span.attrs)
+	ast.True(tracer.span.ended)
+	ast.Equal(err, tracer.span.err)
+}
+
+func TestMetrics(t *testing.T) {
+	ast := require.New(t)
+	defer func() { interceptors = nil }()
+
+	var labels [][]string
+	var documents int64
+	Register(Metrics(MetricsRecorderFunc(func(labelValues []string, duration time.Duration, n int64) {
+		labels = append(labels, labelValues)
+		documents += n
+	})))
+	ctx := context.Background()
+	op := &Operation{Database: "db", Collection: "user", Kind: Find}
+	ast.NoError(Do(ctx, op, func(ctx context.Context) error {
+		op.Returned = 3
+		return nil
+	}))
+	op = &Operation{Database: "db", Collection: "user", Kind: FindOne}
+	ast.Error(Do(ctx, op, func(ctx context.Context) error { return mongo.ErrNoDocuments }))
+	op = &Operation{Database: "db", Collection: "user", Kind: InsertOne}
+	ast.Error(Do(ctx, op, func(ctx context.Context) error { return errors.New("fail") }))
+
+	ast.Equal([][]string{
+		{"db", "user", "find", StatusOK},
+		{"db", "user", "findOne", StatusNotFound},
+		{"db", "user", "insertOne", StatusError},
+	}, labels)
+	ast.Equal(int64(3), documents)
+	ast.Len(MetricLabels, len(labels[0]))
+}
@@ -0,0 +1,67 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MetricLabels are the label names of metrics recorded by Metrics, in the same order as label values
+// They can be used to create Prometheus vectors, like prometheus.NewHistogramVec(opts, interceptor.MetricLabels)
+var MetricLabels = []string{"database", "collection", "operation", "status"}
+
+// Status label values of metrics
+const (
+	StatusOK       = "ok"
+	StatusNotFound = "not_found"
+	StatusError    = "error"
+)
+
+// MetricsRecorder records the metrics of operation, it is usually a thin wrapper of Prometheus vectors:
+//
+//	func (r *recorder) Observe(labelValues []string, duration time.Duration, documents int64) {
+//		r.duration.WithLabelValues(labelValues...).Observe(duration.Seconds())
+//		r.documents.WithLabelValues(labelValues...).Add(float64(documents))
+//	}
+type MetricsRecorder interface {
+	// Observe records one operation, labelValues are in the order of MetricLabels
+	Observe(labelValues []string, duration time.Duration, documents int64)
+}
+
+// MetricsRecorderFunc is the function implementing MetricsRecorder
+type MetricsRecorderFunc func(labelValues []string, duration time.Duration, documents int64)
+
+// Observe calls f
+func (f MetricsRecorderFunc) Observe(labelValues []string, duration time.Duration, documents int64) {
+	f(labelValues, duration, documents)
+}
+
+// Metrics returns the Interceptor which records the duration and documents of every operation into recorder
+func Metrics(recorder MetricsRecorder) Interceptor {
+	return func(ctx context.Context, op *Operation, next Handler) error {
+		err := next(ctx)
+		status := StatusOK
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = StatusNotFound
+		} else if err != nil {
+			status = StatusError
+		}
+		recorder.Observe([]string{op.Database, op.Collection, string(op.Kind), status}, op.Duration, op.Documents())
+		return err
+	}
+}
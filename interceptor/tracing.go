@@ -0,0 +1,65 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package interceptor
+
+import (
+	"context"
+)
+
+// Attribute is the key-value pair set on span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts span, it is usually a thin wrapper of OpenTelemetry trace.Tracer
+type Tracer interface {
+	// Start starts a span as child of the span in ctx, and returns the ctx containing the new span
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is the span started by Tracer, it is usually a thin wrapper of OpenTelemetry trace.Span
+type Span interface {
+	// SetAttributes sets attributes on span
+	SetAttributes(attrs ...Attribute)
+	// End records err if it's not nil, and ends span
+	End(err error)
+}
+
+// Attribute keys follow the semantic conventions of OpenTelemetry for MongoDB
+// Reference: https://opentelemetry.io/docs/specs/semconv/database/mongodb/
+const (
+	AttrDBSystem     = "db.system"
+	AttrDBName       = "db.name"
+	AttrDBOperation  = "db.operation"
+	AttrDBCollection = "db.mongodb.collection"
+	AttrDocuments    = "db.qmgo.documents"
+)
+
+// Tracing returns the Interceptor which starts a span named "operation collection" for every operation
+// The span is the child of the span in ctx, and the ctx containing the span is passed to the operation
+func Tracing(tracer Tracer) Interceptor {
+	return func(ctx context.Context, op *Operation, next Handler) error {
+		ctx, span := tracer.Start(ctx, string(op.Kind)+" "+op.Collection,
+			Attribute{Key: AttrDBSystem, Value: "mongodb"},
+			Attribute{Key: AttrDBName, Value: op.Database},
+			Attribute{Key: AttrDBOperation, Value: string(op.Kind)},
+			Attribute{Key: AttrDBCollection, Value: op.Collection},
+		)
+		err := next(ctx)
+		span.SetAttributes(Attribute{Key: AttrDocuments, Value: op.Documents()})
+		span.End(err)
+		return err
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qiniu/qmgo/interceptor"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestInterceptor(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test_interceptor")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+	ctx := context.Background()
+
+	var ops []interceptor.Operation
+	interceptor.Register(func(ctx context.Context, op *interceptor.Operation, next interceptor.Handler) error {
+		err := next(ctx)
+		if op.Collection == "test_interceptor" {
+			ops = append(ops, *op)
+		}
+		return err
+	})
+
+	docs := []UserInfo{
+		{Id: primitive.NewObjectID(), Name: "Alice", Age: 18},
+		{Id: primitive.NewObjectID(), Name: "Lucas", Age: 20},
+	}
+	_, err := cli.InsertMany(ctx, docs)
+	ast.NoError(err)
+	var res []UserInfo
+	ast.NoError(cli.Find(ctx, bson.M{"age": bson.M{"$gt": 1}}).All(&res))
+	ast.NoError(cli.UpdateOne(ctx, bson.M{"name": "Alice"}, bson.M{"$set": bson.M{"age": 19}}))
+	ast.Equal(ErrNoSuchDocuments, cli.Remove(ctx, bson.M{"name": "Bob"}))
+	_, err = cli.Find(ctx, bson.M{}).Count()
+	ast.NoError(err)
+	ast.NoError(cli.Aggregate(ctx, NewPipeline().Match(bson.M{"name": "Lucas"})).All(&res))
+	_, err = cli.Bulk().InsertOne(UserInfo{Id: primitive.NewObjectID(), Name: "Joe"}).RemoveAll(bson.M{"name": "Alice"}).Run(ctx)
+	ast.NoError(err)
+	// the first batch of cursor is returned
+	cursor := cli.Find(ctx, bson.M{}).BatchSize(1).Cursor()
+	ast.NoError(cursor.Err())
+	ast.NoError(cursor.Close())
+
+	ast.Len(ops, 8)
+	ast.Equal(interceptor.InsertMany, ops[0].Kind)
+	ast.Equal("qmgotest", ops[0].Database)
+	ast.Equal(int64(2), ops[0].Inserted)
+	ast.Equal(interceptor.Find, ops[1].Kind)
+	ast.Equal(bson.M{"age": bson.M{"$gt": 1}}, ops[1].Filter)
+	ast.Equal(int64(2), ops[1].Returned)
+	ast.True(ops[1].Duration > 0)
+	ast.Equal(interceptor.UpdateOne, ops[2].Kind)
+	ast.Equal(int64(1), ops[2].Modified)
+	ast.Equal(interceptor.DeleteOne, ops[3].Kind)
+	ast.Equal(int64(0), ops[3].Deleted)
+	ast.Equal(interceptor.CountDocuments, ops[4].Kind)
+	ast.Equal(int64(2), ops[4].Matched)
+	ast.Equal(interceptor.Aggregate, ops[5].Kind)
+	ast.Equal(int64(1), ops[5].Returned)
+	ast.Equal(interceptor.BulkWrite, ops[6].Kind)
+	ast.Equal(int64(1), ops[6].Inserted)
+	ast.Equal(int64(1), ops[6].Deleted)
+	ast.Equal(interceptor.Find, ops[7].Kind)
+	ast.Equal(int64(1), ops[7].Returned)
+}
@@ -49,6 +49,8 @@ type CursorI interface {
 }
 
 // QueryI Query interface
+// It is implemented by *Query, new methods like Paginate, Page and WithDeleted are added to it as the features grow,
+// so the implementations outside of qmgo, like mocks, should embed QueryI to keep compiling.
 type QueryI interface {
 	Collation(collation *options.Collation) QueryI
 	SetArrayFilters(*options.ArrayFilters) QueryI
@@ -66,12 +68,17 @@ type QueryI interface {
 	Cursor() CursorI
 	Apply(change Change, result interface{}) error
 	Hint(hint interface{}) QueryI
+	Paginate(after PageToken, size int64, result interface{}) (PageCursor, error)
+	Page(page, size int64, result interface{}) (PageInfo, error)
+	WithDeleted() QueryI
 }
 
 // AggregateI define the interface of aggregate
+// It is implemented by *Aggregate, embed AggregateI in the implementations outside of qmgo, the same as QueryI.
 type AggregateI interface {
 	All(results interface{}) error
 	One(result interface{}) error
 	Iter() CursorI // Deprecated, please use Cursor instead
 	Cursor() CursorI
+	WithDeleted() AggregateI
 }
@@ -0,0 +1,22 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package options
+
+// BulkOperationOptions is the options of one operation queued in Bulk
+type BulkOperationOptions struct {
+	// Hook is used by the middlewares instead of the document, it's the only hook of update and remove operations
+	Hook interface{}
+	// SkipMiddleware skips the Before* middlewares and After* hooks of the operation
+	SkipMiddleware bool
+}
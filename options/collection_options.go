@@ -4,4 +4,11 @@ import "go.mongodb.org/mongo-driver/mongo/options"
 
 type CollectionOptions struct {
 	*options.CollectionOptions
+	// Model is the document model of collection, like &User{}
+	// Soft delete is enabled if it implements field.SoftDeleteHook, and its default and custom fields are set
+	// in the operator updates
+	Model interface{}
+	// SoftDeleteField enables soft delete with the bson key of the deletion time, like "deletedAt"
+	// The field must be *time.Time, or time.Time with omitempty, see field.SoftDeleteHook
+	SoftDeleteField string
 }
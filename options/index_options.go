@@ -19,3 +19,9 @@ type IndexModel struct {
 	Key []string // Index key fields; prefix name with dash (-) for descending order
 	*options.IndexOptions
 }
+
+// SyncIndexesOptions defines the options of Collection.SyncIndexesFor
+type SyncIndexesOptions struct {
+	// DropUnused drops the indexes that exist on the server but are not declared in the model
+	DropUnused bool
+}
@@ -1,7 +1,31 @@
 package options
 
-import "go.mongodb.org/mongo-driver/mongo/options"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
 
 type TransactionOptions struct {
 	*options.TransactionOptions
+	// DeferAfterHooks defers the After hooks of write operations in transaction until it's committed,
+	// and drops them if it's aborted
+	DeferAfterHooks bool
+	// Policy controls how the transaction is retried, the retry loop of driver is used if it's nil
+	Policy *TransactionPolicy
+}
+
+// TransactionPolicy controls the retry of transaction on TransientTransactionError and ErrTransactionRetry
+type TransactionPolicy struct {
+	// MaxAttempts is the max number of attempts, default is 3
+	MaxAttempts int
+	// InitialBackoff is the backoff before the first retry, it doubles on every retry, default is 100ms
+	// The actual backoff is randomly chosen from [backoff/2, backoff] as jitter
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of backoff, default is 5s
+	MaxBackoff time.Duration
+	// AttemptTimeout is the timeout of each attempt, including the commit, 0 means no timeout
+	AttemptTimeout time.Duration
+	// OnRetry is called with the error and the number of the failed attempt before retrying
+	OnRetry func(err error, attempt int)
 }
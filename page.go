@@ -0,0 +1,402 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"strings"
+
+	"github.com/qiniu/qmgo/interceptor"
+	"github.com/qiniu/qmgo/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PageToken is the opaque and URL-safe token returned by Query.Paginate to fetch the next or previous page
+// The empty PageToken means the first page
+type PageToken string
+
+// PageCursor holds the tokens of the pages next to the one returned by Query.Paginate
+// Next or Prev is empty if there is no such page
+type PageCursor struct {
+	Next PageToken
+	Prev PageToken
+}
+
+// HasNext returns true if there is a next page
+func (c PageCursor) HasNext() bool {
+	return c.Next != ""
+}
+
+// HasPrev returns true if there is a previous page
+func (c PageCursor) HasPrev() bool {
+	return c.Prev != ""
+}
+
+// pageToken is the content of PageToken, Values holds the sort fields and values of the boundary document
+type pageToken struct {
+	Backward bool   `bson:"b,omitempty"`
+	Values   bson.D `bson:"v"`
+}
+
+// Paginate finds the page of at most size documents after the page token, and decodes them into result
+// The documents are ordered by the fields of Sort, _id is appended as the tie-breaker if it's not sorted,
+// and the page is located by a range filter on the sort fields of the boundary document instead of skip,
+// so it keeps fast on deep pages and stable under concurrent inserts.
+// Pass the empty token to get the first page, and the returned PageCursor.Next or PageCursor.Prev to move.
+// The page token is only valid for the query with the same Sort; Skip and Limit are ignored.
+// Like MongoDB, the null and missing sort fields are ordered before the other values, so the sort fields must be
+// selected if Select is used, and the values of a sort field other than null must be of the same type.
+// The static type of result must be a slice pointer
+func (q *Query) Paginate(after PageToken, size int64, result interface{}) (PageCursor, error) {
+	var page PageCursor
+	if size <= 0 {
+		return page, ErrNotValidPageSize
+	}
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		return page, ErrQueryNotSlicePointer
+	}
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return page, err
+	}
+	sorts := keysetSort(q.sort)
+	token, err := decodePageToken(after, sorts)
+	if err != nil {
+		return page, err
+	}
+
+	filter := q.filter
+	findSort := sorts
+	backward := token != nil && token.Backward
+	if token != nil {
+		rangeFilter := keysetFilter(token.Values, sorts, backward)
+		if filter == nil {
+			filter = rangeFilter
+		} else {
+			filter = bson.D{{Key: operator.And, Value: bson.A{filter, rangeFilter}}}
+		}
+	}
+	if backward {
+		findSort = reverseSort(sorts)
+	}
+	opt := options.Find().SetSort(findSort).SetLimit(size + 1)
+	if q.collation != nil {
+		opt.SetCollation(q.collation)
+	}
+	if q.project != nil {
+		opt.SetProjection(q.project)
+	}
+	if q.hint != nil {
+		opt.SetHint(q.hint)
+	}
+	if q.batchSize != nil {
+		opt.SetBatchSize(int32(*q.batchSize))
+	}
+	if q.noCursorTimeout != nil {
+		opt.SetNoCursorTimeout(*q.noCursorTimeout)
+	}
+	var docs []bson.Raw
+	err = intercept(q.ctx, q.collection, interceptor.Find, filter, func(ctx context.Context, op *interceptor.Operation) error {
+		cursor, err := q.collection.Find(ctx, filter, opt)
+		if err != nil {
+			return err
+		}
+		if err = cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		op.Returned = int64(len(docs))
+		return nil
+	})
+	if err != nil {
+		return page, err
+	}
+
+	more := int64(len(docs)) > size
+	if more {
+		docs = docs[:size]
+	}
+	hasNext, hasPrev := more, token != nil
+	if backward {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+		hasNext, hasPrev = true, more
+	}
+	if len(docs) > 0 {
+		if hasNext {
+			if page.Next, err = encodePageToken(docs[len(docs)-1], sorts, false); err != nil {
+				return page, err
+			}
+		}
+		if hasPrev {
+			if page.Prev, err = encodePageToken(docs[0], sorts, true); err != nil {
+				return page, err
+			}
+		}
+	}
+	if err = decodeRawDocs(docs, result, q.registry); err != nil {
+		return page, err
+	}
+
+	if err := q.afterQuery(hookOp, result); err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// keysetSort returns the sort fields of query, with _id appended as the tie-breaker
+func keysetSort(sort interface{}) bson.D {
+	sorts, _ := sort.(bson.D)
+	res := make(bson.D, 0, len(sorts)+1)
+	hasID := false
+	for _, e := range sorts {
+		if e.Key == "_id" {
+			hasID = true
+		}
+		res = append(res, e)
+	}
+	if !hasID {
+		res = append(res, bson.E{Key: "_id", Value: int32(1)})
+	}
+	return res
+}
+
+// reverseSort reverses the direction of each sort field
+func reverseSort(sorts bson.D) bson.D {
+	res := make(bson.D, 0, len(sorts))
+	for _, e := range sorts {
+		res = append(res, bson.E{Key: e.Key, Value: -sortDirection(e.Value)})
+	}
+	return res
+}
+
+// sortDirection returns 1 for ascending order and -1 for descending order
+func sortDirection(v interface{}) int32 {
+	switch n := v.(type) {
+	case int32:
+		if n < 0 {
+			return -1
+		}
+	case int:
+		if n < 0 {
+			return -1
+		}
+	case int64:
+		if n < 0 {
+			return -1
+		}
+	}
+	return 1
+}
+
+// keysetFilter builds the filter of documents after (or before if backward) the boundary values in sort order
+// For sort {a: 1, b: -1, _id: 1}, it is {$or: [{a: {$gt: va}}, {a: va, b: {$lt: vb}}, {a: va, b: vb, _id: {$gt: vid}}]},
+// see keysetRange for the null values
+func keysetFilter(values bson.D, sorts bson.D, backward bool) bson.D {
+	or := make(bson.A, 0, len(sorts))
+	for i, s := range sorts {
+		rng, ok := keysetRange(s.Key, values[i].Value, (sortDirection(s.Value) < 0) != backward)
+		if !ok {
+			continue
+		}
+		cond := make(bson.D, 0, i+1)
+		for _, v := range values[:i] {
+			cond = append(cond, v)
+		}
+		cond = append(cond, rng)
+		or = append(or, cond)
+	}
+	return bson.D{{Key: operator.Or, Value: or}}
+}
+
+// keysetRange returns the condition of key whose value is after v, the values less than v are after v if less is true
+// The null and missing fields are the least, like the sort order of MongoDB, ok is false if nothing is after v
+func keysetRange(key string, v interface{}, less bool) (cond bson.E, ok bool) {
+	switch {
+	case less && v == nil:
+		return cond, false
+	case less:
+		return bson.E{Key: operator.Or, Value: bson.A{
+			bson.D{{Key: key, Value: bson.D{{Key: operator.Lt, Value: v}}}},
+			bson.D{{Key: key, Value: nil}},
+		}}, true
+	case v == nil:
+		return bson.E{Key: key, Value: bson.D{{Key: operator.Ne, Value: nil}}}, true
+	}
+	return bson.E{Key: key, Value: bson.D{{Key: operator.Gt, Value: v}}}, true
+}
+
+// encodePageToken encodes the sort values of doc into PageToken, the missing sort field is encoded as null
+func encodePageToken(doc bson.Raw, sorts bson.D, backward bool) (PageToken, error) {
+	token := pageToken{Backward: backward, Values: make(bson.D, 0, len(sorts))}
+	for _, s := range sorts {
+		var value interface{}
+		if v, err := doc.LookupErr(strings.Split(s.Key, ".")...); err == nil {
+			value = v
+		}
+		token.Values = append(token.Values, bson.E{Key: s.Key, Value: value})
+	}
+	b, err := bson.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return PageToken(base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// decodePageToken decodes PageToken and checks it matches the sort fields, nil is returned for the empty token
+func decodePageToken(t PageToken, sorts bson.D) (*pageToken, error) {
+	if t == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(string(t))
+	if err != nil {
+		return nil, ErrNotValidPageToken
+	}
+	token := &pageToken{}
+	if err = bson.Unmarshal(b, token); err != nil {
+		return nil, ErrNotValidPageToken
+	}
+	if len(token.Values) != len(sorts) {
+		return nil, ErrNotValidPageToken
+	}
+	for i, s := range sorts {
+		if token.Values[i].Key != s.Key {
+			return nil, ErrNotValidPageToken
+		}
+	}
+	return token, nil
+}
+
+// decodeRawDocs decodes the raw documents into result, which is a slice pointer
+func decodeRawDocs(docs []bson.Raw, result interface{}, registry *bsoncodec.Registry) error {
+	if registry == nil {
+		registry = bson.DefaultRegistry
+	}
+	arr := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		arr = append(arr, doc)
+	}
+	valueType, valueBytes, err := bson.MarshalValueWithRegistry(registry, arr)
+	if err != nil {
+		return err
+	}
+	return bson.RawValue{Type: valueType, Value: valueBytes}.UnmarshalWithRegistry(registry, result)
+}
+
+// PageInfo describes the page returned by Query.Page
+type PageInfo struct {
+	Page    int64 // page number, starts from 1
+	Size    int64 // page size
+	Total   int64 // total number of documents matching the filter
+	Pages   int64 // total number of pages
+	HasNext bool  // whether there is a next page
+}
+
+// Page finds the documents of the page (starts from 1) with size documents per page, decodes them into result,
+// and counts the total documents matching the filter in the same call
+// It runs one aggregation with $facet, which respects Sort, Select, Hint and Collation of the query,
+// Skip and Limit are ignored. The documents are sorted before $facet, so the index of the sort fields can be used.
+// As the page is returned in one document, it must not exceed 16MB.
+// The static type of result must be a slice pointer
+func (q *Query) Page(page, size int64, result interface{}) (PageInfo, error) {
+	info := PageInfo{Page: page, Size: size}
+	if size <= 0 {
+		return info, ErrNotValidPageSize
+	}
+	if page <= 0 {
+		return info, ErrNotValidPageNumber
+	}
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		return info, ErrQueryNotSlicePointer
+	}
+
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return info, err
+	}
+	pipeline := pagePipeline(q.filter, q.sort, q.project, page, size)
+
+	opt := options.Aggregate()
+	if q.collation != nil {
+		opt.SetCollation(q.collation)
+	}
+	if q.hint != nil {
+		opt.SetHint(q.hint)
+	}
+	var res []struct {
+		Items []bson.Raw `bson:"items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	err = intercept(q.ctx, q.collection, interceptor.Aggregate, pipeline, func(ctx context.Context, op *interceptor.Operation) error {
+		cursor, err := q.collection.Aggregate(ctx, pipeline, opt)
+		if err != nil {
+			return err
+		}
+		if err = cursor.All(ctx, &res); err != nil {
+			return err
+		}
+		if len(res) > 0 {
+			op.Returned = int64(len(res[0].Items))
+		}
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	var docs []bson.Raw
+	if len(res) > 0 {
+		docs = res[0].Items
+		if len(res[0].Total) > 0 {
+			info.Total = res[0].Total[0].Count
+		}
+	}
+	info.Pages = (info.Total + size - 1) / size
+	info.HasNext = page < info.Pages
+	if err = decodeRawDocs(docs, result, q.registry); err != nil {
+		return info, err
+	}
+
+	if err := q.afterQuery(hookOp, result); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// pagePipeline builds the pipeline of Query.Page, $sort is before $facet to use the index,
+// as the stages in $facet can't use indexes
+func pagePipeline(filter, sort, project interface{}, page, size int64) Pipeline {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	pipeline := NewPipeline().Match(filter)
+	if sort != nil {
+		pipeline = pipeline.Stage(operator.Sort, sort)
+	}
+	items := NewPipeline().Skip((page - 1) * size).Limit(size)
+	if project != nil {
+		items = items.Project(project)
+	}
+	return pipeline.Facet(map[string]Pipeline{
+		"items": items,
+		"total": NewPipeline().Count("count"),
+	})
+}
@@ -0,0 +1,291 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPageToken(t *testing.T) {
+	ast := require.New(t)
+
+	sorts := keysetSort(bson.D{{Key: "age", Value: int32(-1)}, {Key: "info.name", Value: int32(1)}})
+	ast.Equal(bson.D{{Key: "age", Value: int32(-1)}, {Key: "info.name", Value: int32(1)}, {Key: "_id", Value: int32(1)}}, sorts)
+	ast.Equal(bson.D{{Key: "_id", Value: int32(1)}}, keysetSort(nil))
+	ast.Equal(bson.D{{Key: "_id", Value: int32(-1)}}, keysetSort(bson.D{{Key: "_id", Value: int32(-1)}}))
+
+	doc, err := bson.Marshal(bson.M{"_id": "a", "age": 7, "info": bson.M{"name": "Lucas"}})
+	ast.NoError(err)
+	token, err := encodePageToken(doc, sorts, false)
+	ast.NoError(err)
+	ast.NotContains(token, "+")
+	ast.NotContains(token, "/")
+	ast.NotContains(token, "=")
+
+	pt, err := decodePageToken(token, sorts)
+	ast.NoError(err)
+	ast.False(pt.Backward)
+	ast.Equal(bson.D{{Key: "age", Value: int32(7)}, {Key: "info.name", Value: "Lucas"}, {Key: "_id", Value: "a"}}, pt.Values)
+
+	// null is after the less values
+	lessOrNull := func(key string, v interface{}) bson.E {
+		return bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: key, Value: bson.D{{Key: "$lt", Value: v}}}},
+			bson.D{{Key: key, Value: nil}},
+		}}
+	}
+	expected := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{lessOrNull("age", int32(7))},
+		bson.D{{Key: "age", Value: int32(7)}, {Key: "info.name", Value: bson.D{{Key: "$gt", Value: "Lucas"}}}},
+		bson.D{{Key: "age", Value: int32(7)}, {Key: "info.name", Value: "Lucas"}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: "a"}}}},
+	}}}
+	ast.Equal(expected, keysetFilter(pt.Values, sorts, false))
+	expected = bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: int32(7)}}}},
+		bson.D{{Key: "age", Value: int32(7)}, lessOrNull("info.name", "Lucas")},
+		bson.D{{Key: "age", Value: int32(7)}, {Key: "info.name", Value: "Lucas"}, lessOrNull("_id", "a")},
+	}}}
+	ast.Equal(expected, keysetFilter(pt.Values, sorts, true))
+
+	// token of another sort
+	_, err = decodePageToken(token, keysetSort(nil))
+	ast.Equal(ErrNotValidPageToken, err)
+	_, err = decodePageToken("not a token", sorts)
+	ast.Equal(ErrNotValidPageToken, err)
+
+	// the missing sort field is null, nothing is less than null
+	doc, err = bson.Marshal(bson.M{"_id": "a", "info": bson.M{"name": nil}})
+	ast.NoError(err)
+	token, err = encodePageToken(doc, sorts, false)
+	ast.NoError(err)
+	pt, err = decodePageToken(token, sorts)
+	ast.NoError(err)
+	ast.Equal(bson.D{{Key: "age", Value: nil}, {Key: "info.name", Value: nil}, {Key: "_id", Value: "a"}}, pt.Values)
+	expected = bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "age", Value: nil}, {Key: "info.name", Value: bson.D{{Key: "$ne", Value: nil}}}},
+		bson.D{{Key: "age", Value: nil}, {Key: "info.name", Value: nil}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: "a"}}}},
+	}}}
+	ast.Equal(expected, keysetFilter(pt.Values, sorts, false))
+}
+
+func TestQuery_Paginate(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+	ctx := context.Background()
+
+	docs := []interface{}{
+		pageItem{Id: "1", Name: "Alice", Age: 18},
+		pageItem{Id: "2", Name: "Lucas", Age: 20},
+		pageItem{Id: "3", Name: "Lucas", Age: 18},
+		pageItem{Id: "4", Name: "Lucas", Age: 20},
+		pageItem{Id: "5", Name: "Joe", Age: 19},
+	}
+	_, err := cli.InsertMany(ctx, docs)
+	ast.NoError(err)
+
+	// sort by age desc, _id asc is the tie-breaker
+	var res []pageItem
+	page, err := cli.Find(ctx, bson.M{}).Sort("-age").Paginate("", 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"2", "4"}, itemIds(res))
+	ast.True(page.HasNext())
+	ast.False(page.HasPrev())
+
+	page, err = cli.Find(ctx, bson.M{}).Sort("-age").Paginate(page.Next, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"5", "1"}, itemIds(res))
+	ast.True(page.HasNext())
+	ast.True(page.HasPrev())
+	second := page
+
+	// concurrent insert before the current page doesn't drift the next page
+	_, err = cli.InsertOne(ctx, pageItem{Id: "6", Name: "Joe", Age: 21})
+	ast.NoError(err)
+	page, err = cli.Find(ctx, bson.M{}).Sort("-age").Paginate(page.Next, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"3"}, itemIds(res))
+	ast.False(page.HasNext())
+	ast.True(page.HasPrev())
+
+	// move back
+	page, err = cli.Find(ctx, bson.M{}).Sort("-age").Paginate(page.Prev, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"5", "1"}, itemIds(res))
+	ast.Equal(second.Next, page.Next)
+	page, err = cli.Find(ctx, bson.M{}).Sort("-age").Paginate(page.Prev, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"2", "4"}, itemIds(res))
+	ast.True(page.HasPrev())
+	page, err = cli.Find(ctx, bson.M{}).Sort("-age").Paginate(page.Prev, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"6"}, itemIds(res))
+	ast.False(page.HasPrev())
+	ast.True(page.HasNext())
+
+	// compound sort with filter
+	page, err = cli.Find(ctx, bson.M{"name": "Lucas"}).Sort("age", "-_id").Paginate("", 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"3", "4"}, itemIds(res))
+	page, err = cli.Find(ctx, bson.M{"name": "Lucas"}).Sort("age", "-_id").Paginate(page.Next, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"2"}, itemIds(res))
+	ast.False(page.HasNext())
+
+	// token of another sort
+	_, err = cli.Find(ctx, bson.M{}).Sort("name").Paginate(page.Prev, 2, &res)
+	ast.Equal(ErrNotValidPageToken, err)
+	_, err = cli.Find(ctx, bson.M{}).Paginate("", 0, &res)
+	ast.Equal(ErrNotValidPageSize, err)
+	_, err = cli.Find(ctx, bson.M{}).Paginate("", 2, res)
+	ast.Equal(ErrQueryNotSlicePointer, err)
+
+	// typed
+	items, page, err := As[pageItem](cli.Collection).Find(ctx, bson.M{}).Sort("name").Paginate("", 3)
+	ast.NoError(err)
+	ast.Equal([]string{"1", "5", "6"}, itemIds(items))
+	ast.True(page.HasNext())
+
+	// the missing and null sort fields are the least
+	coll := cli.Database.Collection("test_paginate_null")
+	defer coll.DropCollection(ctx)
+	_, err = coll.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "1", "age": 18}, bson.M{"_id": "2"}, bson.M{"_id": "3", "age": nil}, bson.M{"_id": "4", "age": 20},
+	})
+	ast.NoError(err)
+	for _, c := range []struct {
+		sort  string
+		pages [][]string
+	}{
+		{"age", [][]string{{"2", "3"}, {"1", "4"}}},
+		{"-age", [][]string{{"4", "1"}, {"2", "3"}}},
+	} {
+		var token PageToken
+		for _, ids := range c.pages {
+			page, err = coll.Find(ctx, bson.M{}).Sort(c.sort).Paginate(token, 2, &res)
+			ast.NoError(err)
+			ast.Equal(ids, itemIds(res))
+			token = page.Next
+		}
+		ast.Empty(token)
+	}
+}
+
+func TestPagePipeline(t *testing.T) {
+	ast := require.New(t)
+
+	sort := bson.D{{Key: "age", Value: int32(-1)}}
+	ast.Equal(Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{"name": "Lucas"}}},
+		bson.D{{Key: "$sort", Value: sort}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "items", Value: Pipeline{
+				bson.D{{Key: "$skip", Value: int64(4)}},
+				bson.D{{Key: "$limit", Value: int64(2)}},
+				bson.D{{Key: "$project", Value: bson.M{"name": 1}}},
+			}},
+			{Key: "total", Value: Pipeline{bson.D{{Key: "$count", Value: "count"}}}},
+		}}},
+	}, pagePipeline(bson.M{"name": "Lucas"}, sort, bson.M{"name": 1}, 3, 2))
+
+	ast.Equal(Pipeline{
+		bson.D{{Key: "$match", Value: bson.D{}}},
+		bson.D{{Key: "$facet", Value: bson.D{
+			{Key: "items", Value: Pipeline{
+				bson.D{{Key: "$skip", Value: int64(0)}},
+				bson.D{{Key: "$limit", Value: int64(2)}},
+			}},
+			{Key: "total", Value: Pipeline{bson.D{{Key: "$count", Value: "count"}}}},
+		}}},
+	}, pagePipeline(nil, nil, nil, 1, 2))
+}
+
+func TestQuery_Page(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+	ctx := context.Background()
+
+	docs := []interface{}{
+		pageItem{Id: "1", Name: "Alice", Age: 18},
+		pageItem{Id: "2", Name: "Lucas", Age: 20},
+		pageItem{Id: "3", Name: "Lucas", Age: 18},
+		pageItem{Id: "4", Name: "Lucas", Age: 21},
+		pageItem{Id: "5", Name: "Joe", Age: 19},
+	}
+	_, err := cli.InsertMany(ctx, docs)
+	ast.NoError(err)
+
+	var res []pageItem
+	info, err := cli.Find(ctx, bson.M{}).Sort("-age").Page(1, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"4", "2"}, itemIds(res))
+	ast.Equal(PageInfo{Page: 1, Size: 2, Total: 5, Pages: 3, HasNext: true}, info)
+
+	info, err = cli.Find(ctx, bson.M{}).Sort("-age").Page(3, 2, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"1"}, itemIds(res))
+	ast.False(info.HasNext)
+
+	// out of range
+	info, err = cli.Find(ctx, bson.M{}).Sort("-age").Page(4, 2, &res)
+	ast.NoError(err)
+	ast.Len(res, 0)
+	ast.Equal(int64(5), info.Total)
+
+	// filter, select and hint
+	info, err = cli.Find(ctx, bson.M{"name": "Lucas"}).Sort("age").Select(bson.M{"age": 0}).Hint("_id_").Page(1, 5, &res)
+	ast.NoError(err)
+	ast.Equal([]string{"3", "2", "4"}, itemIds(res))
+	ast.Equal(0, res[0].Age)
+	ast.Equal("Lucas", res[0].Name)
+	ast.Equal(PageInfo{Page: 1, Size: 5, Total: 3, Pages: 1}, info)
+
+	// no documents
+	info, err = cli.Find(ctx, bson.M{"name": "Bob"}).Page(1, 5, &res)
+	ast.NoError(err)
+	ast.Len(res, 0)
+	ast.Equal(int64(0), info.Pages)
+
+	_, err = cli.Find(ctx, bson.M{}).Page(0, 2, &res)
+	ast.Equal(ErrNotValidPageNumber, err)
+	_, err = cli.Find(ctx, bson.M{}).Page(1, 0, &res)
+	ast.Equal(ErrNotValidPageSize, err)
+
+	// typed
+	items, info, err := As[pageItem](cli.Collection).Find(ctx, bson.M{}).Sort("name", "_id").Page(2, 3)
+	ast.NoError(err)
+	ast.Equal([]string{"3", "4"}, itemIds(items))
+	ast.Equal(int64(2), info.Pages)
+}
+
+func itemIds(items []pageItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+type pageItem struct {
+	Id   string `bson:"_id"`
+	Name string `bson:"name"`
+	Age  int    `bson:"age"`
+}
@@ -0,0 +1,197 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/qiniu/qmgo/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// NewPipeline creates an empty Pipeline, stages are appended by chain calls
+// Example: NewPipeline().Match(bson.M{"age": 7}).Group("$name", AccSum("total", "$age")).Sort("-total")
+func NewPipeline() Pipeline {
+	return Pipeline{}
+}
+
+// Stage appends a custom stage, like Stage(operator.Out, "coll")
+// p is not modified, so the pipelines branched from the same p don't affect each other
+func (p Pipeline) Stage(name string, value interface{}) Pipeline {
+	return append(p[:len(p):len(p)], bson.D{{Key: name, Value: value}})
+}
+
+// Match filters the documents to pass only the documents that match the filter
+func (p Pipeline) Match(filter interface{}) Pipeline {
+	return p.Stage(operator.Match, filter)
+}
+
+// Project passes along the documents with the requested fields
+func (p Pipeline) Project(projection interface{}) Pipeline {
+	return p.Stage(operator.Project, projection)
+}
+
+// AddFields adds new fields to documents
+func (p Pipeline) AddFields(fields interface{}) Pipeline {
+	return p.Stage(operator.AddFields, fields)
+}
+
+// Group groups documents by id expression and applies the accumulators to each group
+// The accumulators can be built by AccSum, AccAvg, AccFirst, AccLast, AccMin, AccMax, AccPush and AccAddToSet
+func (p Pipeline) Group(id interface{}, accumulators ...bson.E) Pipeline {
+	group := bson.D{{Key: "_id", Value: id}}
+	group = append(group, accumulators...)
+	return p.Stage(operator.Group, group)
+}
+
+// Sort sorts all documents, same format as Query.Sort
+// Format: "age" or "+age" means ascending order, "-age" means descending order
+// No stage is appended if fields is empty, as $sort requires at least one field
+func (p Pipeline) Sort(fields ...string) Pipeline {
+	if len(fields) == 0 {
+		return p
+	}
+	var sorts bson.D
+	for _, field := range fields {
+		key, n := SplitSortField(field)
+		if key == "" {
+			panic("Sort: empty field name")
+		}
+		sorts = append(sorts, bson.E{Key: key, Value: n})
+	}
+	return p.Stage(operator.Sort, sorts)
+}
+
+// Skip skips the first n documents
+func (p Pipeline) Skip(n int64) Pipeline {
+	return p.Stage(operator.Skip, n)
+}
+
+// Limit passes the first n documents
+func (p Pipeline) Limit(n int64) Pipeline {
+	return p.Stage(operator.Limit, n)
+}
+
+// Lookup performs a left outer join to another collection in the same database
+func (p Pipeline) Lookup(from, localField, foreignField, as string) Pipeline {
+	return p.Stage(operator.Lookup, bson.D{
+		{Key: "from", Value: from},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: foreignField},
+		{Key: "as", Value: as},
+	})
+}
+
+// Unwind deconstructs an array field to output a document for each element
+// Documents whose field is null, missing or empty array are dropped
+func (p Pipeline) Unwind(path string) Pipeline {
+	return p.Stage(operator.Unwind, fieldPath(path))
+}
+
+// UnwindPreserveEmpty is the same as Unwind, but keeps the documents whose field is null, missing or empty array
+func (p Pipeline) UnwindPreserveEmpty(path string) Pipeline {
+	return p.Stage(operator.Unwind, bson.D{
+		{Key: "path", Value: fieldPath(path)},
+		{Key: "preserveNullAndEmptyArrays", Value: true},
+	})
+}
+
+// Facet processes multiple pipelines on the same input documents
+// The key of facets is the output field of each sub-pipeline
+func (p Pipeline) Facet(facets map[string]Pipeline) Pipeline {
+	names := make([]string, 0, len(facets))
+	for name := range facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	facet := bson.D{}
+	for _, name := range names {
+		facet = append(facet, bson.E{Key: name, Value: facets[name]})
+	}
+	return p.Stage(operator.Facet, facet)
+}
+
+// Count passes a document with the count of documents input to the stage
+func (p Pipeline) Count(field string) Pipeline {
+	return p.Stage(operator.Count, field)
+}
+
+// ReplaceRoot replaces the input document with the specified document
+func (p Pipeline) ReplaceRoot(newRoot interface{}) Pipeline {
+	return p.Stage(operator.ReplaceRoot, bson.D{{Key: "newRoot", Value: newRoot}})
+}
+
+// Sample randomly selects n documents
+func (p Pipeline) Sample(n int64) Pipeline {
+	return p.Stage(operator.Sample, bson.D{{Key: "size", Value: n}})
+}
+
+// SortByCount groups documents by the expression and sorts them by count in descending order
+func (p Pipeline) SortByCount(expression interface{}) Pipeline {
+	return p.Stage(operator.SortByCount, expression)
+}
+
+// AccSum returns the accumulator {field: {$sum: expression}} used in Group
+func AccSum(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.Sum, expression)
+}
+
+// AccAvg returns the accumulator {field: {$avg: expression}} used in Group
+func AccAvg(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.Avg, expression)
+}
+
+// AccFirst returns the accumulator {field: {$first: expression}} used in Group
+func AccFirst(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.First, expression)
+}
+
+// AccLast returns the accumulator {field: {$last: expression}} used in Group
+func AccLast(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.Last, expression)
+}
+
+// AccMin returns the accumulator {field: {$min: expression}} used in Group
+func AccMin(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.Min, expression)
+}
+
+// AccMax returns the accumulator {field: {$max: expression}} used in Group
+func AccMax(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.Max, expression)
+}
+
+// AccPush returns the accumulator {field: {$push: expression}} used in Group
+func AccPush(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.Push, expression)
+}
+
+// AccAddToSet returns the accumulator {field: {$addToSet: expression}} used in Group
+func AccAddToSet(field string, expression interface{}) bson.E {
+	return accumulator(field, operator.AddToSet, expression)
+}
+
+// accumulator builds {field: {op: expression}}
+func accumulator(field, op string, expression interface{}) bson.E {
+	return bson.E{Key: field, Value: bson.D{{Key: op, Value: expression}}}
+}
+
+// fieldPath adds the "$" prefix to path if missing
+func fieldPath(path string) string {
+	if strings.HasPrefix(path, "$") {
+		return path
+	}
+	return "$" + path
+}
@@ -18,9 +18,11 @@ import (
 	"fmt"
 	"reflect"
 
-	"github.com/qiniu/qmgo/middleware"
+	"github.com/qiniu/qmgo/hook"
+	"github.com/qiniu/qmgo/interceptor"
 	"github.com/qiniu/qmgo/operator"
 	qOpts "github.com/qiniu/qmgo/options"
+	"github.com/qiniu/qmgo/validator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -44,6 +46,11 @@ type Query struct {
 	collection *mongo.Collection
 	opts       []qOpts.FindOptions
 	registry   *bsoncodec.Registry
+	hooks      *hook.Hooks
+	model      interface{}
+	// softDelete is the bson key of the deletion time if soft delete is enabled
+	softDelete  string
+	withDeleted bool
 }
 
 func (q *Query) Collation(collation *options.Collation) QueryI {
@@ -52,6 +59,13 @@ func (q *Query) Collation(collation *options.Collation) QueryI {
 	return newQ
 }
 
+// WithDeleted makes the query include the soft deleted documents
+func (q *Query) WithDeleted() QueryI {
+	newQ := q
+	newQ.withDeleted = true
+	return newQ
+}
+
 func (q *Query) NoCursorTimeout(n bool) QueryI {
 	newQ := q
 	newQ.noCursorTimeout = &n
@@ -145,10 +159,9 @@ func (q *Query) Limit(n int64) QueryI {
 // One query a record that meets the filter conditions
 // If the search fails, an error will be returned
 func (q *Query) One(result interface{}) error {
-	if len(q.opts) > 0 {
-		if err := middleware.Do(q.ctx, q.opts[0].QueryHook, operator.BeforeQuery); err != nil {
-			return err
-		}
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return err
 	}
 	opt := options.FindOne()
 
@@ -168,15 +181,18 @@ func (q *Query) One(result interface{}) error {
 		opt.SetHint(q.hint)
 	}
 
-	err := q.collection.FindOne(q.ctx, q.filter, opt).Decode(result)
-
+	err = intercept(q.ctx, q.collection, interceptor.FindOne, q.filter, func(ctx context.Context, op *interceptor.Operation) error {
+		if err := q.collection.FindOne(ctx, q.filter, opt).Decode(result); err != nil {
+			return err
+		}
+		op.Returned = 1
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if len(q.opts) > 0 {
-		if err := middleware.Do(q.ctx, q.opts[0].QueryHook, operator.AfterQuery); err != nil {
-			return err
-		}
+	if err := q.afterQuery(hookOp, result); err != nil {
+		return err
 	}
 	return nil
 }
@@ -184,10 +200,9 @@ func (q *Query) One(result interface{}) error {
 // All query multiple records that meet the filter conditions
 // The static type of result must be a slice pointer
 func (q *Query) All(result interface{}) error {
-	if len(q.opts) > 0 {
-		if err := middleware.Do(q.ctx, q.opts[0].QueryHook, operator.BeforeQuery); err != nil {
-			return err
-		}
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return err
 	}
 	opt := options.Find()
 	if q.collation != nil {
@@ -215,30 +230,34 @@ func (q *Query) All(result interface{}) error {
 		opt.SetNoCursorTimeout(*q.noCursorTimeout)
 	}
 
-	var err error
-	var cursor *mongo.Cursor
-
-	cursor, err = q.collection.Find(q.ctx, q.filter, opt)
-
-	c := Cursor{
-		ctx:    q.ctx,
-		cursor: cursor,
-		err:    err,
-	}
-	err = c.All(result)
+	err = intercept(q.ctx, q.collection, interceptor.Find, q.filter, func(ctx context.Context, op *interceptor.Operation) error {
+		cursor, err := q.collection.Find(ctx, q.filter, opt)
+		c := Cursor{
+			ctx:    ctx,
+			cursor: cursor,
+			err:    err,
+		}
+		if err = c.All(result); err != nil {
+			return err
+		}
+		op.Returned = resultLen(result)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if len(q.opts) > 0 {
-		if err := middleware.Do(q.ctx, q.opts[0].QueryHook, operator.AfterQuery); err != nil {
-			return err
-		}
+	if err := q.afterQuery(hookOp, result); err != nil {
+		return err
 	}
 	return nil
 }
 
 // Count count the number of eligible entries
 func (q *Query) Count(opts ...*options.CountOptions) (n int64, err error) {
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return
+	}
 	opt := options.MergeCountOptions(opts...)
 	if q.limit != nil {
 		opt.SetLimit(*q.limit)
@@ -247,14 +266,43 @@ func (q *Query) Count(opts ...*options.CountOptions) (n int64, err error) {
 		opt.SetSkip(*q.skip)
 	}
 
-	return q.collection.CountDocuments(q.ctx, q.filter, opt)
+	err = intercept(q.ctx, q.collection, interceptor.CountDocuments, q.filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		n, err = q.collection.CountDocuments(ctx, q.filter, opt)
+		op.Matched = n
+		return
+	})
+	if err != nil {
+		return
+	}
+	err = q.afterQuery(hookOp, n)
+	return
 }
 
 // EstimatedCount count the number of the collection by using the metadata
+// If soft delete is enabled, the metadata counts the soft deleted documents too, so the documents which are not
+// soft deleted are counted by CountDocuments instead, unless WithDeleted is called
 func (q *Query) EstimatedCount(opts ...*options.EstimatedDocumentCountOptions) (n int64, err error) {
 	co := options.MergeEstimatedDocumentCountOptions(opts...)
-
-	return q.collection.EstimatedDocumentCount(q.ctx, co)
+	if q.softDelete != "" && !q.withDeleted {
+		filter := excludeDeleted(nil, q.softDelete)
+		countOpts := options.Count()
+		if co.MaxTime != nil {
+			countOpts.SetMaxTime(*co.MaxTime)
+		}
+		err = intercept(q.ctx, q.collection, interceptor.CountDocuments, filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+			n, err = q.collection.CountDocuments(ctx, filter, countOpts)
+			op.Matched = n
+			return
+		})
+		return
+	}
+
+	err = intercept(q.ctx, q.collection, interceptor.EstimatedDocumentCount, nil, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		n, err = q.collection.EstimatedDocumentCount(ctx, co)
+		op.Matched = n
+		return
+	})
+	return
 }
 
 // Distinct gets the unique value of the specified field in the collection and return it in the form of slice
@@ -273,8 +321,17 @@ func (q *Query) Distinct(key string, result interface{}) error {
 		return ErrQueryNotSliceType
 	}
 
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return err
+	}
 	opt := options.Distinct()
-	res, err := q.collection.Distinct(q.ctx, key, q.filter, opt)
+	var res []interface{}
+	err = intercept(q.ctx, q.collection, interceptor.Distinct, q.filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		res, err = q.collection.Distinct(ctx, key, q.filter, opt)
+		op.Returned = int64(len(res))
+		return
+	})
 	if err != nil {
 		return err
 	}
@@ -295,12 +352,18 @@ func (q *Query) Distinct(key string, result interface{}) error {
 		return ErrQueryResultTypeInconsistent
 	}
 
-	return nil
+	return q.afterQuery(hookOp, result)
 }
 
 // Cursor gets a Cursor object, which can be used to traverse the query result set
 // After obtaining the CursorI object, you should actively call the Close interface to close the cursor
+// The interceptors see the find command only, the Returned of the operation is the size of the first batch,
+// the later getMore commands are not intercepted
 func (q *Query) Cursor() CursorI {
+	q, hookOp, err := q.beforeQuery(nil)
+	if err != nil {
+		return &Cursor{err: err}
+	}
 	opt := options.Find()
 
 	if q.sort != nil {
@@ -323,9 +386,20 @@ func (q *Query) Cursor() CursorI {
 		opt.SetNoCursorTimeout(*q.noCursorTimeout)
 	}
 
-	var err error
 	var cur *mongo.Cursor
-	cur, err = q.collection.Find(q.ctx, q.filter, opt)
+	err = intercept(q.ctx, q.collection, interceptor.Find, q.filter, func(ctx context.Context, op *interceptor.Operation) (err error) {
+		if cur, err = q.collection.Find(ctx, q.filter, opt); err != nil {
+			return err
+		}
+		op.Returned = int64(cur.RemainingBatchLength())
+		return nil
+	})
+	if err == nil {
+		// the results are not read yet
+		if err = q.afterQuery(hookOp, nil); err != nil {
+			_ = cur.Close(q.ctx)
+		}
+	}
 	return &Cursor{
 		ctx:    q.ctx,
 		cursor: cur,
@@ -347,24 +421,41 @@ func (q *Query) Cursor() CursorI {
 // if no objects are found and Change.Upsert is false, it will returns ErrNoDocuments.
 // When Change.Remove is true, it means delete at most one document in the collection
 // and returns the document as it appeared before deletion; if no objects are found,
-// it will returns ErrNoDocuments.
+// it will returns ErrNoDocuments. If soft delete is enabled, the deletion time of the document is set instead.
 // When both Change.Replace and Change.Remove are false，it means update at most one document
 // in the collection and the update parameter must be a document containing update operators;
 // if no objects are found and Change.Upsert is false, it will returns ErrNoDocuments.
 //
 // reference: https://docs.mongodb.com/manual/reference/command/findAndModify/
 func (q *Query) Apply(change Change, result interface{}) error {
-	var err error
+	q, hookOp, err := q.beforeQuery(change.Update)
+	if err != nil {
+		return err
+	}
 
-	if change.Remove {
+	if change.Remove && q.softDelete != "" {
+		err = q.findOneAndSoftDelete(result)
+	} else if change.Remove {
 		err = q.findOneAndDelete(change, result)
 	} else if change.Replace {
+		if err = validator.Do(q.ctx, change.Update, operator.BeforeReplace); err != nil {
+			return err
+		}
 		err = q.findOneAndReplace(change, result)
 	} else {
+		if err = validator.Update(q.model, change.Update); err != nil {
+			return err
+		}
+		if change.Update, err = withUpdateFields(q.registry, q.model, q.filter, change.Update, change.Upsert); err != nil {
+			return err
+		}
 		err = q.findOneAndUpdate(change, result)
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	return q.afterQuery(hookOp, result)
 }
 
 // findOneAndDelete
@@ -378,7 +469,19 @@ func (q *Query) findOneAndDelete(change Change, result interface{}) error {
 		opts.SetProjection(q.project)
 	}
 
-	return q.collection.FindOneAndDelete(q.ctx, q.filter, opts).Decode(result)
+	return intercept(q.ctx, q.collection, interceptor.FindOneAndDelete, q.filter, func(ctx context.Context, op *interceptor.Operation) error {
+		if err := q.collection.FindOneAndDelete(ctx, q.filter, opts).Decode(result); err != nil {
+			return err
+		}
+		op.Deleted = 1
+		return nil
+	})
+}
+
+// findOneAndSoftDelete sets the deletion time of the document as soft delete, and decodes the document as it
+// appeared before deletion into result
+func (q *Query) findOneAndSoftDelete(result interface{}) error {
+	return q.findOneAndUpdate(Change{Update: bson.M{operator.Set: bson.M{q.softDelete: Now()}}}, result)
 }
 
 // findOneAndReplace
@@ -398,7 +501,13 @@ func (q *Query) findOneAndReplace(change Change, result interface{}) error {
 		opts.SetReturnDocument(options.After)
 	}
 
-	err := q.collection.FindOneAndReplace(q.ctx, q.filter, change.Update, opts).Decode(result)
+	err := intercept(q.ctx, q.collection, interceptor.FindOneAndReplace, q.filter, func(ctx context.Context, op *interceptor.Operation) error {
+		err := q.collection.FindOneAndReplace(ctx, q.filter, change.Update, opts).Decode(result)
+		if err == nil {
+			op.Matched = 1
+		}
+		return err
+	})
 	if change.Upsert && !change.ReturnNew && err == mongo.ErrNoDocuments {
 		return nil
 	}
@@ -427,10 +536,56 @@ func (q *Query) findOneAndUpdate(change Change, result interface{}) error {
 		opts.SetArrayFilters(*q.arrayFilters)
 	}
 
-	err := q.collection.FindOneAndUpdate(q.ctx, q.filter, change.Update, opts).Decode(result)
+	err := intercept(q.ctx, q.collection, interceptor.FindOneAndUpdate, q.filter, func(ctx context.Context, op *interceptor.Operation) error {
+		err := q.collection.FindOneAndUpdate(ctx, q.filter, change.Update, opts).Decode(result)
+		if err == nil {
+			op.Matched = 1
+		}
+		return err
+	})
 	if change.Upsert && !change.ReturnNew && err == mongo.ErrNoDocuments {
 		return nil
 	}
 
 	return err
 }
+
+// beforeQuery calls the before query hooks with the operation of q, the hooks can change the filter, sort,
+// projection and limit of the operation, it returns the copy of q with the changes and the operation
+// The soft deleted documents are excluded from the filter after the hooks
+// The update is the update document of Apply, nil for others
+func (q *Query) beforeQuery(update interface{}) (*Query, *hook.Operation, error) {
+	op := &hook.Operation{
+		Collection: q.collection.Name(),
+		Filter:     q.filter,
+		Sort:       q.sort,
+		Projection: q.project,
+		Limit:      q.limit,
+		Update:     update,
+	}
+	nq := *q
+	nq.ctx = hook.WithOperation(q.ctx, op)
+	if err := nq.doHook(operator.BeforeQuery); err != nil {
+		return nil, nil, err
+	}
+	nq.filter, nq.sort, nq.project, nq.limit = op.Filter, op.Sort, op.Projection, op.Limit
+	if q.softDelete != "" && !q.withDeleted {
+		nq.filter = excludeDeleted(nq.filter, q.softDelete)
+	}
+	return &nq, op, nil
+}
+
+// afterQuery calls the after query hooks with the result of query
+func (q *Query) afterQuery(op *hook.Operation, result interface{}) error {
+	op.Result = result
+	return q.doHook(operator.AfterQuery)
+}
+
+// doHook calls the middlewares with QueryHook in opts and the hook functions of collection
+func (q *Query) doHook(opType operator.OpType) error {
+	var h interface{}
+	if len(q.opts) > 0 {
+		h = q.opts[0].QueryHook
+	}
+	return doHooks(q.ctx, q.hooks, q.model, h, opType)
+}
@@ -19,6 +19,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/qiniu/qmgo/filter"
 	"github.com/qiniu/qmgo/operator"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
@@ -886,3 +887,37 @@ func TestQuery_BatchSize(t *testing.T) {
 	ast.Len(res, 2)
 
 }
+
+func TestQuery_FilterBuilder(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	defer cli.Close(context.Background())
+	defer cli.DropCollection(context.Background())
+
+	docs := []interface{}{
+		bson.M{"_id": primitive.NewObjectID(), "name": "Alice", "age": 18, "tags": bson.A{"a", "b"}},
+		bson.M{"_id": primitive.NewObjectID(), "name": "Alice", "age": 19, "tags": bson.A{"b"}},
+		bson.M{"_id": primitive.NewObjectID(), "name": "Lucas", "age": 20},
+	}
+	_, _ = cli.InsertMany(context.Background(), docs)
+
+	var res []QueryTestItem
+	err := cli.Find(context.Background(), filter.And(filter.Eq("name", "Alice"), filter.Gt("age", 18))).All(&res)
+	ast.NoError(err)
+	ast.Len(res, 1)
+	ast.Equal(19, res[0].Age)
+
+	n, err := cli.Find(context.Background(), filter.Or(filter.In("age", 18, 20), filter.All("tags", "b"))).Count()
+	ast.NoError(err)
+	ast.Equal(int64(3), n)
+
+	n, err = cli.Find(context.Background(), filter.Regex("name", "^al", "i")).Count()
+	ast.NoError(err)
+	ast.Equal(int64(2), n)
+
+	result, err := cli.UpdateAll(context.Background(), filter.Exists("tags", false), bson.M{operator.Set: bson.M{"age": 30}})
+	ast.NoError(err)
+	ast.Equal(int64(1), result.ModifiedCount)
+
+	ast.NoError(cli.Remove(context.Background(), filter.Eq("age", 30)))
+}
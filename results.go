@@ -37,3 +37,13 @@ type UpdateResult struct {
 type DeleteResult struct {
 	DeletedCount int64 // The number of documents deleted.
 }
+
+// UpsertManyResult is the result type returned by UpsertManyBy operation.
+type UpsertManyResult struct {
+	// The _id of every inserted document, keyed by the index of the doc.
+	InsertedIDs map[int]interface{}
+	// The indexes of docs which match the existing documents.
+	Updated []int
+	// The number of existing documents modified by the operation.
+	ModifiedCount int64
+}
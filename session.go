@@ -15,6 +15,9 @@ package qmgo
 
 import (
 	"context"
+	"errors"
+	"math/rand"
+	"time"
 
 	opts "github.com/qiniu/qmgo/options"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -39,12 +42,27 @@ type Session struct {
 //     the whole transaction will retry, so this transaction must be idempotent
 //   - if operations in callback return qmgo.ErrTransactionNotSupported,
 //   - If the ctx parameter already has a Session attached to it, it will be replaced by this session.
+//   - side effects in callback, like publishing events, should be registered by OnCommit or OnAbort,
+//     which are called once after the transaction is finally committed or aborted
+//   - with TransactionOptions.Policy, the transaction is retried by the policy instead of the driver,
+//     and *TransactionError is returned if it finally fails
 func (s *Session) StartTransaction(ctx context.Context, cb func(sessCtx context.Context) (interface{}, error), opts ...*opts.TransactionOptions) (interface{}, error) {
 	transactionOpts := options.Transaction()
 	if len(opts) > 0 && opts[0].TransactionOptions != nil {
 		transactionOpts = opts[0].TransactionOptions
 	}
-	result, err := s.session.WithTransaction(ctx, wrapperCustomCb(cb), transactionOpts)
+	if len(opts) > 0 && opts[0].Policy != nil {
+		return s.transactionWithPolicy(ctx, cb, transactionOpts, opts[0])
+	}
+	var cbs *txCallbacks
+	result, err := s.session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// callbacks registered in the previous attempt are dropped
+		cbs = &txCallbacks{deferHooks: len(opts) > 0 && opts[0].DeferAfterHooks}
+		return wrapperCustomCb(cb)(withTxCallbacks(sessCtx, cbs))
+	}, transactionOpts)
+	if cbs != nil {
+		cbs.run(ctx, err == nil)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -57,19 +75,39 @@ func (s *Session) StartAsyncTransaction(ctx context.Context, opts ...*opts.Trans
 		transactionOpts = opts[0].TransactionOptions
 	}
 
-	sCtx := mongo.NewSessionContext(ctx, s.session)
+	cbs := &txCallbacks{deferHooks: len(opts) > 0 && opts[0].DeferAfterHooks}
+	sCtx := withTxCallbacks(mongo.NewSessionContext(ctx, s.session), cbs)
 
 	err := s.session.StartTransaction(transactionOpts)
 
 	return sCtx, err
 }
 
+// CommitAsyncTransaction commits the transaction started by StartAsyncTransaction
+// The callbacks registered by OnCommit are called after the transaction is committed, and the ones registered by
+// OnAbort are called if the commit fails, except the error with UnknownTransactionCommitResult label,
+// which means the commit can be retried by calling CommitAsyncTransaction again.
 func (s *Session) CommitAsyncTransaction(ctx context.Context) error {
-	return s.session.CommitTransaction(ctx)
+	err := s.session.CommitTransaction(ctx)
+	if err != nil && isUnknownCommitResult(err) {
+		return err
+	}
+	if cbs, ok := ctx.Value(txCallbacksKey{}).(*txCallbacks); ok {
+		cbs.run(ctx, err == nil)
+	}
+	return err
 }
 
+// AbortAsyncTransaction aborts the transaction started by StartAsyncTransaction
+// The callbacks registered by OnAbort are called after the transaction is aborted
 func (s *Session) AbortAsyncTransaction(ctx context.Context) error {
-	return s.session.AbortTransaction(ctx)
+	if err := s.session.AbortTransaction(ctx); err != nil {
+		return err
+	}
+	if cbs, ok := ctx.Value(txCallbacksKey{}).(*txCallbacks); ok {
+		cbs.run(ctx, false)
+	}
+	return nil
 }
 
 // EndSession will abort any existing transactions and close the session.
@@ -83,6 +121,137 @@ func (s *Session) AbortTransaction(ctx context.Context) error {
 	return s.session.AbortTransaction(ctx)
 }
 
+// transactionWithPolicy runs the transaction and retries it by the policy in opt instead of the driver
+func (s *Session) transactionWithPolicy(ctx context.Context, cb func(sessCtx context.Context) (interface{}, error),
+	transactionOpts *options.TransactionOptions, opt *opts.TransactionOptions) (interface{}, error) {
+	policy := *opt.Policy
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultTransactionMaxAttempts
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = defaultTransactionInitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultTransactionMaxBackoff
+	}
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		cbs := &txCallbacks{deferHooks: opt.DeferAfterHooks}
+		result, err := s.transactionAttempt(ctx, cb, transactionOpts, cbs, policy)
+		if err == nil {
+			cbs.run(ctx, true)
+			return result, nil
+		}
+		if attempt >= policy.MaxAttempts || !isTransientTransactionError(err) || ctx.Err() != nil {
+			cbs.run(ctx, false)
+			return nil, &TransactionError{Attempts: attempt, Err: err}
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(err, attempt)
+		}
+		select {
+		case <-ctx.Done():
+			cbs.run(ctx, false)
+			return nil, &TransactionError{Attempts: attempt, Err: ctx.Err()}
+		case <-time.After(jitter(backoff)):
+		}
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// transactionAttempt runs one attempt of transaction, it's aborted if cb or commit fails
+func (s *Session) transactionAttempt(ctx context.Context, cb func(sessCtx context.Context) (interface{}, error),
+	transactionOpts *options.TransactionOptions, cbs *txCallbacks, policy opts.TransactionPolicy) (interface{}, error) {
+	attemptCtx := ctx
+	if policy.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, policy.AttemptTimeout)
+		defer cancel()
+	}
+	if err := s.session.StartTransaction(transactionOpts); err != nil {
+		return nil, err
+	}
+	sessCtx := withTxCallbacks(mongo.NewSessionContext(attemptCtx, s.session), cbs)
+	result, err := wrapperCustomCb(cb)(sessCtx)
+	if err == nil {
+		err = s.commitTransaction(sessCtx, policy)
+	}
+	if err != nil {
+		// the transaction may be committed or aborted already, abort is no-op then
+		_ = s.session.AbortTransaction(ctx)
+		return nil, err
+	}
+	return result, nil
+}
+
+// commitTransaction commits the transaction, the commit with unknown result is retried with jittered backoff
+// until the result is known, ctx is done or transactionCommitTimeout passes
+func (s *Session) commitTransaction(ctx context.Context, policy opts.TransactionPolicy) error {
+	deadline := time.Now().Add(transactionCommitTimeout)
+	backoff := policy.InitialBackoff
+	for {
+		err := s.session.CommitTransaction(ctx)
+		if !isUnknownCommitResult(err) {
+			return err
+		}
+		wait := jitter(backoff)
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// transactionCommitTimeout is the time limit of retrying the commit, the same as WithTransaction of driver
+var transactionCommitTimeout = 120 * time.Second
+
+const (
+	defaultTransactionMaxAttempts    = 3
+	defaultTransactionInitialBackoff = 100 * time.Millisecond
+	defaultTransactionMaxBackoff     = 5 * time.Second
+)
+
+// jitter returns the random duration in [d/2, d]
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// isTransientTransactionError checks if the whole transaction can be retried after err
+func isTransientTransactionError(err error) bool {
+	var le labeledError
+	return errors.As(err, &le) && le.HasErrorLabel(driver.TransientTransactionError)
+}
+
+// isUnknownCommitResult checks if the commit can be retried after err
+func isUnknownCommitResult(err error) bool {
+	var le labeledError
+	return errors.As(err, &le) && le.HasErrorLabel(driver.UnknownTransactionCommitResult)
+}
+
+// labeledError is the error with labels, like mongo.CommandError
+type labeledError interface {
+	HasErrorLabel(string) bool
+}
+
+// withTxCallbacks returns the session context carrying cbs
+func withTxCallbacks(sessCtx mongo.SessionContext, cbs *txCallbacks) mongo.SessionContext {
+	return mongo.NewSessionContext(context.WithValue(sessCtx, txCallbacksKey{}, cbs), sessCtx)
+}
+
 // wrapperCustomF wrapper caller's callback function to mongo dirver's
 func wrapperCustomCb(cb func(ctx context.Context) (interface{}, error)) func(sessCtx mongo.SessionContext) (interface{}, error) {
 	return func(sessCtx mongo.SessionContext) (interface{}, error) {
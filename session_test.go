@@ -18,12 +18,15 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	opts "github.com/qiniu/qmgo/options"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
 )
 
 func initTransactionClient(coll string) *QmgoClient {
@@ -249,3 +252,248 @@ func TestSession_RetryTransAction(t *testing.T) {
 	ast.Equal(r["xyz"], int32(999))
 	ast.Equal(count, 1)
 }
+
+type txHookUser struct {
+	Name   string `bson:"name"`
+	events *[]string
+}
+
+func (u *txHookUser) AfterInsert(ctx context.Context) error {
+	*u.events = append(*u.events, "afterInsert")
+	return nil
+}
+
+func TestOnCommit(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+
+	// not in transaction
+	var events []string
+	OnCommit(ctx, func(ctx context.Context) { events = append(events, "commit") })
+	OnAbort(ctx, func(ctx context.Context) { events = append(events, "abort") })
+	ast.Equal([]string{"commit"}, events)
+
+	cli := initTransactionClient("test")
+	defer cli.DropCollection(ctx)
+
+	// only the callbacks of final attempt run once
+	events = nil
+	count := 0
+	_, err := cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		OnCommit(sCtx, func(ctx context.Context) { events = append(events, fmt.Sprint("commit", count)) })
+		OnAbort(sCtx, func(ctx context.Context) { events = append(events, fmt.Sprint("abort", count)) })
+		if _, err := cli.InsertOne(sCtx, bson.M{"name": "on_commit"}); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			count++
+			return nil, ErrTransactionRetry
+		}
+		return nil, nil
+	})
+	ast.NoError(err)
+	ast.Equal([]string{"commit1"}, events)
+
+	events = nil
+	_, err = cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		OnCommit(sCtx, func(ctx context.Context) { events = append(events, "commit") })
+		OnAbort(sCtx, func(ctx context.Context) { events = append(events, "abort") })
+		return nil, errors.New("cancel")
+	})
+	ast.Error(err)
+	ast.Equal([]string{"abort"}, events)
+
+	// deferred After hooks
+	events = nil
+	_, err = cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		if _, err := cli.InsertOne(sCtx, &txHookUser{Name: "deferred", events: &events}); err != nil {
+			return nil, err
+		}
+		ast.Len(events, 0)
+		return nil, nil
+	}, &opts.TransactionOptions{DeferAfterHooks: true})
+	ast.NoError(err)
+	ast.Equal([]string{"afterInsert"}, events)
+
+	events = nil
+	_, err = cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		if _, err := cli.InsertOne(sCtx, &txHookUser{Name: "dropped", events: &events}); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("cancel")
+	}, &opts.TransactionOptions{DeferAfterHooks: true})
+	ast.Error(err)
+	ast.Len(events, 0)
+
+	// async transaction
+	s, err := cli.Session()
+	ast.NoError(err)
+	defer s.EndSession(ctx)
+	events = nil
+	sCtx, err := s.StartAsyncTransaction(ctx)
+	ast.NoError(err)
+	OnCommit(sCtx, func(ctx context.Context) { events = append(events, "commit") })
+	_, err = cli.InsertOne(sCtx, bson.M{"name": "async"})
+	ast.NoError(err)
+	ast.NoError(s.CommitAsyncTransaction(sCtx))
+	ast.Equal([]string{"commit"}, events)
+}
+
+func TestTransactionRetryHelpers(t *testing.T) {
+	ast := require.New(t)
+
+	for i := 0; i < 100; i++ {
+		d := jitter(100 * time.Millisecond)
+		ast.True(d >= 50*time.Millisecond && d <= 100*time.Millisecond)
+	}
+	ast.Equal(time.Duration(1), jitter(1))
+
+	ast.True(isTransientTransactionError(mongo.CommandError{Labels: []string{driver.TransientTransactionError}}))
+	ast.False(isTransientTransactionError(errors.New("fail")))
+	ast.True(isUnknownCommitResult(mongo.CommandError{Labels: []string{driver.UnknownTransactionCommitResult}}))
+	ast.False(isUnknownCommitResult(nil))
+
+	err := &TransactionError{Attempts: 3, Err: ErrTransactionRetry}
+	ast.EqualError(err, "transaction failed after 3 attempts: retry transaction")
+	ast.True(errors.Is(err, ErrTransactionRetry))
+}
+
+// commitSession is the session whose commit fails with the errors in order, then succeeds
+type commitSession struct {
+	mongo.Session
+	errs    []error
+	commits int
+}
+
+func (s *commitSession) CommitTransaction(ctx context.Context) error {
+	s.commits++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func TestSession_CommitAsyncTransaction(t *testing.T) {
+	ast := require.New(t)
+	unknown := mongo.CommandError{Labels: []string{driver.UnknownTransactionCommitResult}}
+
+	var events []string
+	cbs := &txCallbacks{}
+	ctx := context.WithValue(context.Background(), txCallbacksKey{}, cbs)
+	OnCommit(ctx, func(ctx context.Context) { events = append(events, "commit") })
+	OnAbort(ctx, func(ctx context.Context) { events = append(events, "abort") })
+
+	// the commit can be retried
+	s := &Session{session: &commitSession{errs: []error{unknown, errors.New("fail")}}}
+	ast.Equal(unknown, s.CommitAsyncTransaction(ctx))
+	ast.Len(events, 0)
+	// the transaction is aborted
+	ast.EqualError(s.CommitAsyncTransaction(ctx), "fail")
+	ast.Equal([]string{"abort"}, events)
+	// called once
+	ast.NoError(s.CommitAsyncTransaction(ctx))
+	ast.Equal([]string{"abort"}, events)
+}
+
+func TestSession_CommitTransaction(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+	unknown := mongo.CommandError{Labels: []string{driver.UnknownTransactionCommitResult}}
+	policy := opts.TransactionPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+
+	sess := &commitSession{errs: []error{unknown, unknown}}
+	start := time.Now()
+	ast.NoError((&Session{session: sess}).commitTransaction(ctx, policy))
+	ast.Equal(3, sess.commits)
+	// backoff between retries
+	ast.True(time.Since(start) >= 5*time.Millisecond+10*time.Millisecond)
+
+	// not retried
+	sess = &commitSession{errs: []error{errors.New("fail")}}
+	ast.EqualError((&Session{session: sess}).commitTransaction(ctx, policy), "fail")
+	ast.Equal(1, sess.commits)
+
+	// the retries stop at the timeout
+	defer func(d time.Duration) { transactionCommitTimeout = d }(transactionCommitTimeout)
+	transactionCommitTimeout = 50 * time.Millisecond
+	sess = &commitSession{errs: make([]error, 1000)}
+	for i := range sess.errs {
+		sess.errs[i] = unknown
+	}
+	ast.Equal(unknown, (&Session{session: sess}).commitTransaction(ctx, policy))
+	ast.True(sess.commits > 1 && sess.commits < 10)
+
+	// and when ctx is done
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	sess = &commitSession{errs: []error{unknown, unknown}}
+	ast.Equal(unknown, (&Session{session: sess}).commitTransaction(cctx, policy))
+	ast.Equal(1, sess.commits)
+}
+
+func TestTransactionPolicy(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+	cli := initTransactionClient("test")
+	defer cli.DropDatabase(ctx)
+
+	var retries []int
+	policy := &opts.TransactionPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		OnRetry:        func(err error, attempt int) { retries = append(retries, attempt) },
+	}
+
+	// succeed in the second attempt
+	attempts := 0
+	_, err := cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		attempts++
+		if _, err := cli.InsertOne(sCtx, bson.M{"name": "policy"}); err != nil {
+			return nil, err
+		}
+		if attempts == 1 {
+			return nil, ErrTransactionRetry
+		}
+		return nil, nil
+	}, &opts.TransactionOptions{Policy: policy})
+	ast.NoError(err)
+	ast.Equal(2, attempts)
+	ast.Equal([]int{1}, retries)
+	n, err := cli.Find(ctx, bson.M{"name": "policy"}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(1), n)
+
+	// fail after max attempts
+	attempts, retries = 0, nil
+	var events []string
+	_, err = cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		attempts++
+		OnAbort(sCtx, func(ctx context.Context) { events = append(events, "abort") })
+		return nil, ErrTransactionRetry
+	}, &opts.TransactionOptions{Policy: policy})
+	var txErr *TransactionError
+	ast.True(errors.As(err, &txErr))
+	ast.Equal(3, txErr.Attempts)
+	ast.Equal(3, attempts)
+	ast.Equal([]int{1, 2}, retries)
+	ast.Equal([]string{"abort"}, events)
+
+	// not retryable error
+	attempts = 0
+	_, err = cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		attempts++
+		return nil, errors.New("fail")
+	}, &opts.TransactionOptions{Policy: policy})
+	ast.True(errors.As(err, &txErr))
+	ast.Equal(1, txErr.Attempts)
+	ast.EqualError(txErr.Err, "fail")
+
+	// attempt timeout
+	_, err = cli.DoTransaction(ctx, func(sCtx context.Context) (interface{}, error) {
+		<-sCtx.Done()
+		return nil, sCtx.Err()
+	}, &opts.TransactionOptions{Policy: &opts.TransactionPolicy{AttemptTimeout: 10 * time.Millisecond}})
+	ast.True(errors.Is(err, context.DeadlineExceeded))
+}
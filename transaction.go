@@ -0,0 +1,124 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/qiniu/qmgo/hook"
+	"github.com/qiniu/qmgo/middleware"
+	"github.com/qiniu/qmgo/operator"
+)
+
+// txCallbacksKey is the key of txCallbacks in context
+type txCallbacksKey struct{}
+
+// txCallbacks holds the callbacks registered in one attempt of transaction
+type txCallbacks struct {
+	mu         sync.Mutex
+	commit     []func(ctx context.Context)
+	abort      []func(ctx context.Context)
+	deferHooks bool
+}
+
+// OnCommit registers fn called once after the transaction of sessCtx is committed
+// The callbacks are called in order of registration, only the ones registered in the final attempt are called
+// if the transaction is retried. If sessCtx is not in the transaction started by DoTransaction, StartTransaction
+// or StartAsyncTransaction, fn is called immediately, as the writes are applied immediately without transaction.
+func OnCommit(sessCtx context.Context, fn func(ctx context.Context)) {
+	cbs, ok := sessCtx.Value(txCallbacksKey{}).(*txCallbacks)
+	if !ok {
+		fn(sessCtx)
+		return
+	}
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+	cbs.commit = append(cbs.commit, fn)
+}
+
+// OnAbort registers fn called once after the transaction of sessCtx is finally aborted
+// The callbacks are called in order of registration, the ones registered in the attempts retried are dropped.
+// If sessCtx is not in the transaction started by DoTransaction, StartTransaction or StartAsyncTransaction,
+// fn is dropped, as there is nothing to abort, unlike OnCommit which calls fn immediately.
+func OnAbort(sessCtx context.Context, fn func(ctx context.Context)) {
+	cbs, ok := sessCtx.Value(txCallbacksKey{}).(*txCallbacks)
+	if !ok {
+		return
+	}
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+	cbs.abort = append(cbs.abort, fn)
+}
+
+// run calls the commit or abort callbacks once
+func (cbs *txCallbacks) run(ctx context.Context, committed bool) {
+	cbs.mu.Lock()
+	fns := cbs.abort
+	if committed {
+		fns = cbs.commit
+	}
+	cbs.commit, cbs.abort = nil, nil
+	cbs.mu.Unlock()
+	for _, fn := range fns {
+		fn(ctx)
+	}
+}
+
+// len returns the number of commit and abort callbacks registered
+func (cbs *txCallbacks) len() (commits, aborts int) {
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+	return len(cbs.commit), len(cbs.abort)
+}
+
+// rollback drops the callbacks registered after the first commits and aborts ones, the abort callbacks dropped are
+// called in order of registration
+func (cbs *txCallbacks) rollback(ctx context.Context, commits, aborts int) {
+	cbs.mu.Lock()
+	fns := append([]func(ctx context.Context){}, cbs.abort[aborts:]...)
+	cbs.commit, cbs.abort = cbs.commit[:commits], cbs.abort[:aborts]
+	cbs.mu.Unlock()
+	for _, fn := range fns {
+		fn(ctx)
+	}
+}
+
+// doAfterHook calls the middlewares and hook functions with the After opType of write operation, see doHooks
+// If the transaction of ctx defers After hooks, they're called after the transaction is committed,
+// and the error returned is ignored as the transaction can't be rolled back
+func doAfterHook(ctx context.Context, hooks *hook.Hooks, model, doc interface{}, opType operator.OpType, opts ...interface{}) error {
+	if cbs, ok := ctx.Value(txCallbacksKey{}).(*txCallbacks); ok && cbs.deferHooks {
+		op := hook.OperationFromContext(ctx)
+		OnCommit(ctx, func(ctx context.Context) {
+			if op != nil {
+				ctx = hook.WithOperation(ctx, op)
+			}
+			_ = doHooks(ctx, hooks, model, doc, opType, opts...)
+		})
+		return nil
+	}
+	return doHooks(ctx, hooks, model, doc, opType, opts...)
+}
+
+// doHooks calls the middlewares with doc, then the hook functions of the type of doc, or model if doc is nil, and hooks
+// The middlewares are skipped if doc is nil, as there is nothing to work on
+func doHooks(ctx context.Context, hooks *hook.Hooks, model, doc interface{}, opType operator.OpType, opts ...interface{}) error {
+	if doc != nil {
+		if err := middleware.Do(ctx, doc, opType, opts...); err != nil {
+			return err
+		}
+	}
+	return hook.DoFuncs(ctx, doc, model, opType, hooks)
+}
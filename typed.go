@@ -0,0 +1,324 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/qiniu/qmgo/field"
+	opts "github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TypedCollection is a handle to a MongoDB collection whose documents are decoded into T
+// It is a thin wrapper of Collection, so hooks, default/custom fields and validator work as usual
+type TypedCollection[T any] struct {
+	coll *Collection
+}
+
+// As returns a TypedCollection which operates the documents of c as T
+// If c has neither model nor soft delete, soft delete is enabled if T implements field.SoftDeleteHook.
+// T is not used as the model of c, set CollectionOptions.Model to have the model features, like validation.
+// Example: users, err := qmgo.As[User](coll).Find(ctx, bson.M{"age": 7}).All()
+func As[T any](c *Collection) *TypedCollection[T] {
+	if c.model != nil || c.softDelete != "" {
+		return &TypedCollection[T]{coll: c}
+	}
+	softDelete := field.SoftDeleteFieldOf(newModel[T]())
+	if softDelete == "" {
+		return &TypedCollection[T]{coll: c}
+	}
+	cp := *c
+	cp.softDelete = softDelete
+	return &TypedCollection[T]{coll: &cp}
+}
+
+// Collection returns the underlying untyped Collection
+func (c *TypedCollection[T]) Collection() *Collection {
+	return c.coll
+}
+
+// Find find by condition filter，return TypedQuery
+func (c *TypedCollection[T]) Find(ctx context.Context, filter interface{}, opts ...opts.FindOptions) *TypedQuery[T] {
+	return &TypedQuery[T]{query: c.coll.Find(ctx, filter, opts...)}
+}
+
+// InsertOne insert one document into the collection
+// If T is not a pointer type, hooks and default fields work on a copy of doc
+func (c *TypedCollection[T]) InsertOne(ctx context.Context, doc T, opts ...opts.InsertOneOptions) (*InsertOneResult, error) {
+	return c.coll.InsertOne(ctx, typedDoc(&doc), opts...)
+}
+
+// InsertMany executes an insert command to insert multiple documents into the collection.
+// If T is not a pointer type, hooks and default fields work on the elements of docs in place
+func (c *TypedCollection[T]) InsertMany(ctx context.Context, docs []T, opts ...opts.InsertManyOptions) (*InsertManyResult, error) {
+	return c.coll.InsertMany(ctx, typedDocs(docs), opts...)
+}
+
+// Upsert updates one documents if filter match, inserts one document if filter is not match
+// Reference: Collection.Upsert
+func (c *TypedCollection[T]) Upsert(ctx context.Context, filter interface{}, replacement T, opts ...opts.UpsertOptions) (*UpdateResult, error) {
+	return c.coll.Upsert(ctx, filter, typedDoc(&replacement), opts...)
+}
+
+// UpsertId updates one documents if id match, inserts one document if id is not match
+// Reference: Collection.UpsertId
+func (c *TypedCollection[T]) UpsertId(ctx context.Context, id interface{}, replacement T, opts ...opts.UpsertOptions) (*UpdateResult, error) {
+	return c.coll.UpsertId(ctx, id, typedDoc(&replacement), opts...)
+}
+
+// UpsertManyBy upserts every document of docs by the keyFields
+// Reference: Collection.UpsertManyBy
+func (c *TypedCollection[T]) UpsertManyBy(ctx context.Context, keyFields []string, docs []T) (*UpsertManyResult, error) {
+	return c.coll.UpsertManyBy(ctx, keyFields, docs)
+}
+
+// ReplaceOne executes an update command to update at most one document in the collection.
+// Reference: Collection.ReplaceOne
+func (c *TypedCollection[T]) ReplaceOne(ctx context.Context, filter interface{}, doc T, opts ...opts.ReplaceOptions) error {
+	return c.coll.ReplaceOne(ctx, filter, typedDoc(&doc), opts...)
+}
+
+// UpdateOne executes an update command to update at most one document in the collection.
+// The update parameter must contain update operators, so it's not typed
+func (c *TypedCollection[T]) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...opts.UpdateOptions) error {
+	return c.coll.UpdateOne(ctx, filter, update, opts...)
+}
+
+// UpdateId executes an update command to update at most one document in the collection.
+func (c *TypedCollection[T]) UpdateId(ctx context.Context, id interface{}, update interface{}, opts ...opts.UpdateOptions) error {
+	return c.coll.UpdateId(ctx, id, update, opts...)
+}
+
+// UpdateAll executes an update command to update documents in the collection.
+func (c *TypedCollection[T]) UpdateAll(ctx context.Context, filter interface{}, update interface{}, opts ...opts.UpdateOptions) (*UpdateResult, error) {
+	return c.coll.UpdateAll(ctx, filter, update, opts...)
+}
+
+// Remove executes a delete command to delete at most one document from the collection.
+func (c *TypedCollection[T]) Remove(ctx context.Context, filter interface{}, opts ...opts.RemoveOptions) error {
+	return c.coll.Remove(ctx, filter, opts...)
+}
+
+// RemoveId executes a delete command to delete at most one document from the collection.
+func (c *TypedCollection[T]) RemoveId(ctx context.Context, id interface{}, opts ...opts.RemoveOptions) error {
+	return c.coll.RemoveId(ctx, id, opts...)
+}
+
+// RemoveAll executes a delete command to delete documents from the collection.
+func (c *TypedCollection[T]) RemoveAll(ctx context.Context, filter interface{}, opts ...opts.RemoveOptions) (*DeleteResult, error) {
+	return c.coll.RemoveAll(ctx, filter, opts...)
+}
+
+// Restore restores the soft deleted documents matching filter
+// Reference: Collection.Restore
+func (c *TypedCollection[T]) Restore(ctx context.Context, filter interface{}) (*UpdateResult, error) {
+	return c.coll.Restore(ctx, filter)
+}
+
+// HardRemove deletes the documents matching filter from the collection even if soft delete is enabled
+func (c *TypedCollection[T]) HardRemove(ctx context.Context, filter interface{}, opts ...opts.RemoveOptions) (*DeleteResult, error) {
+	return c.coll.HardRemove(ctx, filter, opts...)
+}
+
+// WatchStream returns a ChangeStream for all changes on the collection, the full documents are decoded into T
+// Reference: WatchStream
+func (c *TypedCollection[T]) WatchStream(ctx context.Context, pipeline interface{}, store ResumeTokenStore,
+	opts ...*opts.ChangeStreamOptions) (*ChangeStream[T], error) {
+	return WatchStream[T](ctx, c.coll, pipeline, store, opts...)
+}
+
+// TypedQuery is the typed version of QueryI, results are decoded into T
+type TypedQuery[T any] struct {
+	query QueryI
+}
+
+// Collation sets the value for the Collation field.
+func (q *TypedQuery[T]) Collation(collation *options.Collation) *TypedQuery[T] {
+	q.query = q.query.Collation(collation)
+	return q
+}
+
+// SetArrayFilters use for apply update array
+func (q *TypedQuery[T]) SetArrayFilters(filter *options.ArrayFilters) *TypedQuery[T] {
+	q.query = q.query.SetArrayFilters(filter)
+	return q
+}
+
+// Sort is Used to set the sorting rules for the returned results
+// Reference: Query.Sort
+func (q *TypedQuery[T]) Sort(fields ...string) *TypedQuery[T] {
+	q.query = q.query.Sort(fields...)
+	return q
+}
+
+// Select is used to determine which fields are displayed or not displayed in the returned results
+func (q *TypedQuery[T]) Select(projection interface{}) *TypedQuery[T] {
+	q.query = q.query.Select(projection)
+	return q
+}
+
+// Skip skip n records
+func (q *TypedQuery[T]) Skip(n int64) *TypedQuery[T] {
+	q.query = q.query.Skip(n)
+	return q
+}
+
+// BatchSize sets the value for the BatchSize field.
+func (q *TypedQuery[T]) BatchSize(n int64) *TypedQuery[T] {
+	q.query = q.query.BatchSize(n)
+	return q
+}
+
+// NoCursorTimeout sets the value for the NoCursorTimeout field.
+func (q *TypedQuery[T]) NoCursorTimeout(n bool) *TypedQuery[T] {
+	q.query = q.query.NoCursorTimeout(n)
+	return q
+}
+
+// Limit limits the maximum number of documents found to n
+func (q *TypedQuery[T]) Limit(n int64) *TypedQuery[T] {
+	q.query = q.query.Limit(n)
+	return q
+}
+
+// Hint sets the value for the Hint field.
+func (q *TypedQuery[T]) Hint(hint interface{}) *TypedQuery[T] {
+	q.query = q.query.Hint(hint)
+	return q
+}
+
+// One query a record that meets the filter conditions
+func (q *TypedQuery[T]) One() (result T, err error) {
+	err = q.query.One(&result)
+	return
+}
+
+// All query multiple records that meet the filter conditions
+func (q *TypedQuery[T]) All() (results []T, err error) {
+	results = []T{}
+	err = q.query.All(&results)
+	return
+}
+
+// Paginate finds the page of at most size documents after the page token
+// Reference: Query.Paginate
+func (q *TypedQuery[T]) Paginate(after PageToken, size int64) (results []T, page PageCursor, err error) {
+	results = []T{}
+	page, err = q.query.Paginate(after, size, &results)
+	return
+}
+
+// Page finds the documents of the page and counts the total documents in one call
+// Reference: Query.Page
+func (q *TypedQuery[T]) Page(page, size int64) (results []T, info PageInfo, err error) {
+	results = []T{}
+	info, err = q.query.Page(page, size, &results)
+	return
+}
+
+// WithDeleted makes the query include the soft deleted documents
+func (q *TypedQuery[T]) WithDeleted() *TypedQuery[T] {
+	q.query = q.query.WithDeleted()
+	return q
+}
+
+// Count count the number of eligible entries
+func (q *TypedQuery[T]) Count(opts ...*options.CountOptions) (int64, error) {
+	return q.query.Count(opts...)
+}
+
+// EstimatedCount count the number of the collection by using the metadata
+func (q *TypedQuery[T]) EstimatedCount(opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	return q.query.EstimatedCount(opts...)
+}
+
+// Distinct gets the unique value of the specified field in the collection
+// The values are not of type T, so result should be passed a pointer to slice
+func (q *TypedQuery[T]) Distinct(key string, result interface{}) error {
+	return q.query.Distinct(key, result)
+}
+
+// Cursor gets a TypedCursor object, which can be used to traverse the query result set
+// After obtaining the TypedCursor object, you should actively call the Close interface to close the cursor
+func (q *TypedQuery[T]) Cursor() *TypedCursor[T] {
+	return &TypedCursor[T]{cursor: q.query.Cursor()}
+}
+
+// Apply runs the findAndModify command and returns the old or new version of the document
+// Reference: Query.Apply
+func (q *TypedQuery[T]) Apply(change Change) (result T, err error) {
+	err = q.query.Apply(change, &result)
+	return
+}
+
+// TypedCursor is the typed version of CursorI
+type TypedCursor[T any] struct {
+	cursor CursorI
+}
+
+// Next gets the next document for this cursor. It returns true if there were no errors and the cursor has not been
+// exhausted.
+func (c *TypedCursor[T]) Next(result *T) bool {
+	return c.cursor.Next(result)
+}
+
+// All iterates the cursor and decodes each document into T.
+func (c *TypedCursor[T]) All() (results []T, err error) {
+	results = []T{}
+	err = c.cursor.All(&results)
+	return
+}
+
+// Close closes this cursor.
+func (c *TypedCursor[T]) Close() error {
+	return c.cursor.Close()
+}
+
+// Err return the last error of Cursor, if no error occurs, return nil
+func (c *TypedCursor[T]) Err() error {
+	return c.cursor.Err()
+}
+
+// newModel returns the pointer to the zero value of T, or of the type T points to if T is pointer
+func newModel[T any]() interface{} {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return reflect.New(t).Interface()
+}
+
+// typedDoc returns the document passed to Collection
+// A pointer is needed for hooks and fields to change the document, unless T is pointer already
+func typedDoc[T any](doc *T) interface{} {
+	if reflect.TypeOf(doc).Elem().Kind() == reflect.Ptr {
+		return *doc
+	}
+	return doc
+}
+
+// typedDocs returns the documents passed to Collection
+// If T is not pointer type, the pointers of elements are used, so hooks and fields change docs in place
+func typedDocs[T any](docs []T) interface{} {
+	if reflect.TypeOf(docs).Elem().Kind() == reflect.Ptr {
+		return docs
+	}
+	refs := make([]*T, 0, len(docs))
+	for i := range docs {
+		refs = append(refs, &docs[i])
+	}
+	return refs
+}
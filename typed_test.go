@@ -0,0 +1,138 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/operator"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type TypedUser struct {
+	field.DefaultField `bson:",inline"`
+
+	Name string `bson:"name"`
+	Age  int    `bson:"age"`
+}
+
+func TestAs(t *testing.T) {
+	ast := require.New(t)
+
+	// T is not used as the model
+	c := &Collection{}
+	typed := As[TypedUser](c)
+	ast.Same(c, typed.Collection())
+	ast.Nil(typed.Collection().model)
+
+	// only the soft delete field of T is detected
+	soft := As[*softDeleteUser](c)
+	ast.Nil(soft.Collection().model)
+	ast.Equal("deletedAt", soft.Collection().softDelete)
+	ast.Empty(c.softDelete)
+
+	// the model and soft delete of collection are kept
+	c = &Collection{model: &TypedUser{}}
+	ast.Same(c, As[softDeleteUser](c).Collection())
+	c = &Collection{softDelete: "removedAt"}
+	ast.Equal("removedAt", As[softDeleteUser](c).Collection().softDelete)
+}
+
+func TestTypedCollection(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	defer cli.DropCollection(ctx)
+
+	users := As[TypedUser](cli.Collection)
+	_, err := users.InsertOne(ctx, TypedUser{Name: "Lucas", Age: 7})
+	ast.NoError(err)
+
+	docs := []TypedUser{{Name: "Alice", Age: 8}, {Name: "Bob", Age: 9}}
+	_, err = users.InsertMany(ctx, docs)
+	ast.NoError(err)
+	// default fields are set on the elements of docs
+	ast.False(docs[0].Id.IsZero())
+	ast.False(docs[1].CreateAt.IsZero())
+
+	one, err := users.Find(ctx, bson.M{"name": "Lucas"}).One()
+	ast.NoError(err)
+	ast.Equal(7, one.Age)
+	ast.False(one.Id.IsZero())
+
+	all, err := users.Find(ctx, bson.M{}).Sort("-age").All()
+	ast.NoError(err)
+	ast.Len(all, 3)
+	ast.Equal("Bob", all[0].Name)
+
+	all, err = users.Find(ctx, bson.M{"age": 100}).All()
+	ast.NoError(err)
+	ast.Len(all, 0)
+
+	_, err = users.Find(ctx, bson.M{"age": 100}).One()
+	ast.Equal(ErrNoSuchDocuments, err)
+
+	cursor := users.Find(ctx, bson.M{}).Sort("age").Cursor()
+	var u TypedUser
+	ast.True(cursor.Next(&u))
+	ast.Equal("Lucas", u.Name)
+	ast.NoError(cursor.Close())
+
+	ast.NoError(users.UpdateOne(ctx, bson.M{"name": "Lucas"}, bson.M{operator.Set: bson.M{"age": 17}}))
+	newDoc, err := users.Find(ctx, bson.M{"name": "Lucas"}).Apply(Change{
+		Update:    bson.M{operator.Inc: bson.M{"age": 1}},
+		ReturnNew: true,
+	})
+	ast.NoError(err)
+	ast.Equal(18, newDoc.Age)
+
+	ptrUsers := As[*TypedUser](cli.Collection)
+	p := &TypedUser{Name: "Joe", Age: 10}
+	_, err = ptrUsers.InsertOne(ctx, p)
+	ast.NoError(err)
+	ast.False(p.Id.IsZero())
+
+	pOne, err := ptrUsers.Find(ctx, bson.M{"name": "Joe"}).One()
+	ast.NoError(err)
+	ast.Equal(p.Id, pOne.Id)
+
+	res, err := users.RemoveAll(ctx, bson.M{})
+	ast.NoError(err)
+	ast.Equal(int64(4), res.DeletedCount)
+}
+
+func TestTypedDocs(t *testing.T) {
+	ast := require.New(t)
+
+	u := TypedUser{Name: "Lucas"}
+	_, ok := typedDoc(&u).(*TypedUser)
+	ast.True(ok)
+	p := &u
+	_, ok = typedDoc(&p).(*TypedUser)
+	ast.True(ok)
+
+	values := []TypedUser{{Name: "Lucas"}, {Name: "Alice"}}
+	refs, ok := typedDocs(values).([]*TypedUser)
+	ast.True(ok)
+	refs[0].Age = 7
+	ast.Equal(7, values[0].Age)
+
+	ptrs := []*TypedUser{{Name: "Lucas"}}
+	_, ok = typedDocs(ptrs).([]*TypedUser)
+	ast.True(ok)
+}
@@ -0,0 +1,108 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+
+	opts "github.com/qiniu/qmgo/options"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unitOfWorkKey is the key of UnitOfWork in context
+type unitOfWorkKey struct{}
+
+// UnitOfWork is one transaction, the collections got from it are bound to the session of the transaction,
+// so the operations on them join the transaction even if the ctx passed in has no session
+//
+// UnitOfWork is not safe for concurrent use.
+type UnitOfWork struct {
+	ctx     context.Context
+	session mongo.Session
+	// callbacks are the OnCommit and OnAbort callbacks of the transaction attempt
+	callbacks *txCallbacks
+}
+
+// WithUnitOfWork runs fn in a transaction, the transaction is committed if fn returns nil, and aborted otherwise
+// If ctx is the context of a UnitOfWork already, like uow.Context(), the outer UnitOfWork is reused by a Savepoint
+// instead of starting a new transaction.
+// fn may be called multiple times when the transaction is retried, so it must be idempotent,
+// the rollback callbacks of the aborted attempt, including the ones registered by OnAbort, are called before retrying.
+// precondition: the same as DoTransaction
+func (c *Client) WithUnitOfWork(ctx context.Context, fn func(uow *UnitOfWork) error, opts ...*opts.TransactionOptions) error {
+	if outer, ok := ctx.Value(unitOfWorkKey{}).(*UnitOfWork); ok {
+		return outer.Savepoint(fn)
+	}
+	if !c.transactionAllowed() {
+		return ErrTransactionNotSupported
+	}
+	s, err := c.Session()
+	if err != nil {
+		return err
+	}
+	defer s.EndSession(ctx)
+
+	var uow *UnitOfWork
+	_, err = s.StartTransaction(ctx, func(sessCtx context.Context) (interface{}, error) {
+		if uow != nil {
+			// the previous attempt is aborted, StartTransaction drops its callbacks
+			uow.callbacks.run(ctx, false)
+		}
+		cbs, _ := sessCtx.Value(txCallbacksKey{}).(*txCallbacks)
+		uow = &UnitOfWork{session: s.session, callbacks: cbs}
+		uow.ctx = context.WithValue(sessCtx, unitOfWorkKey{}, uow)
+		return nil, fn(uow)
+	}, opts...)
+	return err
+}
+
+// Context returns the context bound to the session of UnitOfWork
+// Pass it to WithUnitOfWork to reuse the UnitOfWork in nested calls
+func (u *UnitOfWork) Context() context.Context {
+	return u.ctx
+}
+
+// Collection returns the copy of coll bound to the session of UnitOfWork
+func (u *UnitOfWork) Collection(coll *Collection) *Collection {
+	cp := *coll
+	cp.session = u.session
+	return &cp
+}
+
+// OnRollback registers cb to undo side effects, it is called if the transaction or the enclosing Savepoint
+// is rolled back. It is the same as OnAbort with the ctx of UnitOfWork, the callbacks are called in order of
+// registration, with the ctx of UnitOfWork for Savepoint, and the ctx outside of transaction for the aborted transaction.
+func (u *UnitOfWork) OnRollback(cb func(ctx context.Context)) {
+	OnAbort(u.ctx, cb)
+}
+
+// AfterCommit registers cb called once after the transaction is committed, in order of registration,
+// with the ctx outside of transaction. It is the same as OnCommit with the ctx of UnitOfWork.
+func (u *UnitOfWork) AfterCommit(cb func(ctx context.Context)) {
+	OnCommit(u.ctx, cb)
+}
+
+// Savepoint runs fn in the same transaction, if fn returns error, the rollback callbacks registered in fn are called
+// and discarded, the after-commit callbacks registered in fn are discarded, and the error is returned,
+// the caller can handle it and continue the transaction.
+// The callbacks registered by OnAbort and OnCommit in fn, like the deferred After hooks, are handled the same way.
+// As MongoDB has no savepoint, the writes in fn are only undone by the rollback callbacks or aborting the transaction.
+func (u *UnitOfWork) Savepoint(fn func(uow *UnitOfWork) error) error {
+	commits, aborts := u.callbacks.len()
+	if err := fn(u); err != nil {
+		u.callbacks.rollback(u.ctx, commits, aborts)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package qmgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUnitOfWork_Savepoint(t *testing.T) {
+	ast := require.New(t)
+	cbs := &txCallbacks{}
+	uow := &UnitOfWork{ctx: context.WithValue(context.Background(), txCallbacksKey{}, cbs), callbacks: cbs}
+
+	var calls []string
+	uow.OnRollback(func(ctx context.Context) { calls = append(calls, "outer") })
+	uow.AfterCommit(func(ctx context.Context) { calls = append(calls, "commit") })
+	err := uow.Savepoint(func(uow *UnitOfWork) error {
+		uow.OnRollback(func(ctx context.Context) { calls = append(calls, "inner1") })
+		OnAbort(uow.Context(), func(ctx context.Context) { calls = append(calls, "inner2") })
+		uow.AfterCommit(func(ctx context.Context) { calls = append(calls, "inner commit") })
+		return errors.New("fail")
+	})
+	ast.EqualError(err, "fail")
+	ast.Equal([]string{"inner1", "inner2"}, calls)
+	ast.Len(cbs.abort, 1)
+	ast.Len(cbs.commit, 1)
+
+	ast.NoError(uow.Savepoint(func(uow *UnitOfWork) error {
+		uow.OnRollback(func(ctx context.Context) { calls = append(calls, "inner3") })
+		return nil
+	}))
+	ast.Len(cbs.abort, 2)
+
+	calls = nil
+	cbs.run(context.Background(), false)
+	ast.Equal([]string{"outer", "inner3"}, calls)
+	ast.Len(cbs.abort, 0)
+	ast.Len(cbs.commit, 0)
+}
+
+func TestClient_WithUnitOfWork(t *testing.T) {
+	ast := require.New(t)
+	ctx := context.Background()
+	cli := initTransactionClient("test")
+	defer cli.DropDatabase(ctx)
+
+	var calls []string
+	err := cli.WithUnitOfWork(ctx, func(uow *UnitOfWork) error {
+		coll := uow.Collection(cli.Collection)
+		// ctx without session joins the transaction
+		if _, err := coll.InsertOne(ctx, bson.M{"name": "uow_a"}); err != nil {
+			return err
+		}
+		uow.AfterCommit(func(ctx context.Context) { calls = append(calls, "commit") })
+
+		// nested call reuses the transaction
+		return cli.WithUnitOfWork(uow.Context(), func(inner *UnitOfWork) error {
+			ast.Same(uow, inner)
+			_, err := inner.Collection(cli.Collection).InsertOne(ctx, bson.M{"name": "uow_b"})
+			return err
+		})
+	})
+	ast.NoError(err)
+	ast.Equal([]string{"commit"}, calls)
+	n, err := cli.Find(ctx, bson.M{"name": bson.M{"$in": []string{"uow_a", "uow_b"}}}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(2), n)
+
+	// abort
+	calls = nil
+	err = cli.WithUnitOfWork(ctx, func(uow *UnitOfWork) error {
+		if _, err := uow.Collection(cli.Collection).InsertOne(ctx, bson.M{"name": "uow_c"}); err != nil {
+			return err
+		}
+		uow.OnRollback(func(ctx context.Context) { calls = append(calls, "rollback") })
+		uow.AfterCommit(func(ctx context.Context) { calls = append(calls, "commit") })
+		return errors.New("fail")
+	})
+	ast.EqualError(err, "fail")
+	ast.Equal([]string{"rollback"}, calls)
+	n, err = cli.Find(ctx, bson.M{"name": "uow_c"}).Count()
+	ast.NoError(err)
+	ast.Equal(int64(0), n)
+
+	// retry
+	calls = nil
+	attempts := 0
+	err = cli.WithUnitOfWork(ctx, func(uow *UnitOfWork) error {
+		attempts++
+		uow.OnRollback(func(ctx context.Context) { calls = append(calls, "rollback") })
+		uow.AfterCommit(func(ctx context.Context) { calls = append(calls, "commit") })
+		if attempts == 1 {
+			return ErrTransactionRetry
+		}
+		return nil
+	})
+	ast.NoError(err)
+	ast.Equal(2, attempts)
+	ast.Equal([]string{"rollback", "commit"}, calls)
+}
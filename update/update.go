@@ -0,0 +1,402 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package update builds update documents with chain calls instead of nested bson.M
+// The Document can be passed to any API accepting an update, like Collection.UpdateOne and Bulk.UpdateOne
+// Example: update.Set("name", "Lucas").Inc("age", 1).Push("tags", update.Each("a", "b").Slice(-5))
+// refer: https://docs.mongodb.com/manual/reference/operator/update/
+package update
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/qiniu/qmgo/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// ErrReplacementContainUpdateOperators return if replacement fields and update operators are mixed in one document
+	ErrReplacementContainUpdateOperators = errors.New("replacement document cannot contain keys beginning with '$'")
+	// ErrNotValidDocument return if the document passed to From is not a map or bson.D
+	ErrNotValidDocument = errors.New("update document must be a map or bson.D")
+)
+
+// Document is the update document built by chain calls
+// Document implements bson.Marshaler, error happened during building is returned when marshaling
+type Document struct {
+	doc bson.D
+	err error
+}
+
+// New creates an empty update Document
+func New() *Document {
+	return &Document{doc: bson.D{}}
+}
+
+// From creates update Document from a raw document, like bson.M or bson.D
+// If all keys of doc are update operators, they are kept as they are
+// If none of the keys is an update operator, doc is treated as the fields to $set
+// Otherwise ErrReplacementContainUpdateOperators is returned when building
+func From(doc interface{}) *Document {
+	d := New()
+	elems, err := toElements(doc)
+	if err != nil {
+		d.err = err
+		return d
+	}
+	operators := 0
+	for _, e := range elems {
+		if strings.HasPrefix(e.Key, "$") {
+			operators++
+		}
+	}
+	if operators > 0 && operators != len(elems) {
+		d.err = ErrReplacementContainUpdateOperators
+		return d
+	}
+	for _, e := range elems {
+		if operators == 0 {
+			d.add(operator.Set, e.Key, e.Value)
+			continue
+		}
+		fields, err := toElements(e.Value)
+		if err != nil {
+			d.err = err
+			return d
+		}
+		for _, f := range fields {
+			d.add(e.Key, f.Key, f.Value)
+		}
+	}
+	return d
+}
+
+// Set sets the value of a field
+func Set(field string, value interface{}) *Document {
+	return New().Set(field, value)
+}
+
+// SetOnInsert sets the value of a field if an update results in an insert of a document
+func SetOnInsert(field string, value interface{}) *Document {
+	return New().SetOnInsert(field, value)
+}
+
+// Unset removes the specified fields
+func Unset(fields ...string) *Document {
+	return New().Unset(fields...)
+}
+
+// Inc increments the value of the field by the specified amount
+func Inc(field string, value interface{}) *Document {
+	return New().Inc(field, value)
+}
+
+// Mul multiplies the value of the field by the specified amount
+func Mul(field string, value interface{}) *Document {
+	return New().Mul(field, value)
+}
+
+// Min only updates the field if the specified value is less than the existing field value
+func Min(field string, value interface{}) *Document {
+	return New().Min(field, value)
+}
+
+// Max only updates the field if the specified value is greater than the existing field value
+func Max(field string, value interface{}) *Document {
+	return New().Max(field, value)
+}
+
+// Rename renames a field
+func Rename(field, newName string) *Document {
+	return New().Rename(field, newName)
+}
+
+// CurrentDate sets the value of a field to current date
+func CurrentDate(field string) *Document {
+	return New().CurrentDate(field)
+}
+
+// Push adds an item to an array, value can be a Modifier built by Each
+func Push(field string, value interface{}) *Document {
+	return New().Push(field, value)
+}
+
+// AddToSet adds elements to an array only if they do not already exist in the set
+// value can be a Modifier built by Each
+func AddToSet(field string, value interface{}) *Document {
+	return New().AddToSet(field, value)
+}
+
+// Pull removes all array elements that match a specified query
+func Pull(field string, condition interface{}) *Document {
+	return New().Pull(field, condition)
+}
+
+// PullAll removes all matching values from an array
+func PullAll[V any](field string, values ...V) *Document {
+	return New().add(operator.PullAll, field, toArray(values))
+}
+
+// PopFirst removes the first item of an array
+func PopFirst(field string) *Document {
+	return New().PopFirst(field)
+}
+
+// PopLast removes the last item of an array
+func PopLast(field string) *Document {
+	return New().PopLast(field)
+}
+
+// Set sets the value of a field
+func (d *Document) Set(field string, value interface{}) *Document {
+	return d.add(operator.Set, field, value)
+}
+
+// SetOnInsert sets the value of a field if an update results in an insert of a document
+func (d *Document) SetOnInsert(field string, value interface{}) *Document {
+	return d.add(operator.SetOnInsert, field, value)
+}
+
+// Unset removes the specified fields
+func (d *Document) Unset(fields ...string) *Document {
+	for _, f := range fields {
+		d.add(operator.Unset, f, "")
+	}
+	return d
+}
+
+// Inc increments the value of the field by the specified amount
+func (d *Document) Inc(field string, value interface{}) *Document {
+	return d.add(operator.Inc, field, value)
+}
+
+// Mul multiplies the value of the field by the specified amount
+func (d *Document) Mul(field string, value interface{}) *Document {
+	return d.add(operator.Mul, field, value)
+}
+
+// Min only updates the field if the specified value is less than the existing field value
+func (d *Document) Min(field string, value interface{}) *Document {
+	return d.add(operator.Min, field, value)
+}
+
+// Max only updates the field if the specified value is greater than the existing field value
+func (d *Document) Max(field string, value interface{}) *Document {
+	return d.add(operator.Max, field, value)
+}
+
+// Rename renames a field
+func (d *Document) Rename(field, newName string) *Document {
+	return d.add(operator.Rename, field, newName)
+}
+
+// CurrentDate sets the value of a field to current date
+func (d *Document) CurrentDate(field string) *Document {
+	return d.add(operator.CurrentDate, field, true)
+}
+
+// Push adds an item to an array, value can be a Modifier built by Each
+func (d *Document) Push(field string, value interface{}) *Document {
+	return d.add(operator.Push, field, value)
+}
+
+// AddToSet adds elements to an array only if they do not already exist in the set
+// value can be a Modifier built by Each
+func (d *Document) AddToSet(field string, value interface{}) *Document {
+	return d.add(operator.AddToSet, field, value)
+}
+
+// Pull removes all array elements that match a specified query
+func (d *Document) Pull(field string, condition interface{}) *Document {
+	return d.add(operator.Pull, field, condition)
+}
+
+// PullAll removes all matching values from an array
+// values is a slice, use the function PullAll for typed values
+func (d *Document) PullAll(field string, values ...interface{}) *Document {
+	return d.add(operator.PullAll, field, toArray(values))
+}
+
+// PopFirst removes the first item of an array
+func (d *Document) PopFirst(field string) *Document {
+	return d.add(operator.Pop, field, -1)
+}
+
+// PopLast removes the last item of an array
+func (d *Document) PopLast(field string) *Document {
+	return d.add(operator.Pop, field, 1)
+}
+
+// BitAnd performs a bitwise and update of a field
+func (d *Document) BitAnd(field string, value int64) *Document {
+	return d.add(operator.Bit, field, bson.D{{Key: "and", Value: value}})
+}
+
+// BitOr performs a bitwise or update of a field
+func (d *Document) BitOr(field string, value int64) *Document {
+	return d.add(operator.Bit, field, bson.D{{Key: "or", Value: value}})
+}
+
+// BitXor performs a bitwise xor update of a field
+func (d *Document) BitXor(field string, value int64) *Document {
+	return d.add(operator.Bit, field, bson.D{{Key: "xor", Value: value}})
+}
+
+// Merge merges the operators and fields of other into d
+func (d *Document) Merge(other *Document) *Document {
+	if other.err != nil && d.err == nil {
+		d.err = other.err
+	}
+	for _, op := range other.doc {
+		for _, f := range op.Value.(bson.D) {
+			d.add(op.Key, f.Key, f.Value)
+		}
+	}
+	return d
+}
+
+// Err returns the error happened during building
+func (d *Document) Err() error {
+	return d.err
+}
+
+// Build returns the update document
+func (d *Document) Build() (bson.D, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.doc, nil
+}
+
+// MarshalBSON implements bson.Marshaler, so Document can be passed as update directly
+func (d *Document) MarshalBSON() ([]byte, error) {
+	doc, err := d.Build()
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc)
+}
+
+// add sets {op: {field: value}} in the document
+// The value of same operator and field is overwritten
+func (d *Document) add(op, field string, value interface{}) *Document {
+	if m, ok := value.(*Modifier); ok {
+		value = m.build()
+	}
+	for i, e := range d.doc {
+		if e.Key != op {
+			continue
+		}
+		fields := e.Value.(bson.D)
+		for j, f := range fields {
+			if f.Key == field {
+				fields[j].Value = value
+				return d
+			}
+		}
+		d.doc[i].Value = append(fields, bson.E{Key: field, Value: value})
+		return d
+	}
+	d.doc = append(d.doc, bson.E{Key: op, Value: bson.D{{Key: field, Value: value}}})
+	return d
+}
+
+// Modifier defines the array modifiers used with Push and AddToSet
+// refer: https://docs.mongodb.com/manual/reference/operator/update/push/#modifiers
+type Modifier struct {
+	each     bson.A
+	position *int
+	slice    *int
+	sort     interface{}
+}
+
+// Each appends multiple values to the array field
+func Each[V any](values ...V) *Modifier {
+	return &Modifier{each: toArray(values)}
+}
+
+// Position specifies the location in the array at which to insert the new elements, only works with Push
+func (m *Modifier) Position(n int) *Modifier {
+	m.position = &n
+	return m
+}
+
+// Slice limits the number of array elements, only works with Push
+func (m *Modifier) Slice(n int) *Modifier {
+	m.slice = &n
+	return m
+}
+
+// Sort orders elements of the array, only works with Push
+// Use 1 or -1 for array of scalars, and document like bson.D{{"score", -1}} for array of documents
+func (m *Modifier) Sort(sort interface{}) *Modifier {
+	m.sort = sort
+	return m
+}
+
+// build returns the modifier document
+func (m *Modifier) build() bson.D {
+	doc := bson.D{{Key: operator.Each, Value: m.each}}
+	if m.position != nil {
+		doc = append(doc, bson.E{Key: operator.Position, Value: *m.position})
+	}
+	if m.slice != nil {
+		doc = append(doc, bson.E{Key: operator.Slice, Value: *m.slice})
+	}
+	if m.sort != nil {
+		doc = append(doc, bson.E{Key: operator.Sort, Value: m.sort})
+	}
+	return doc
+}
+
+// toArray converts the typed values to bson.A
+func toArray[V any](values []V) bson.A {
+	res := make(bson.A, 0, len(values))
+	for _, v := range values {
+		res = append(res, v)
+	}
+	return res
+}
+
+// toElements converts map or bson.D to elements
+func toElements(doc interface{}) ([]bson.E, error) {
+	switch d := doc.(type) {
+	case bson.D:
+		return d, nil
+	case bson.M:
+		return mapToElements(d), nil
+	case map[string]interface{}:
+		return mapToElements(d), nil
+	}
+	v := reflect.ValueOf(doc)
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+		elems := make([]bson.E, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elems = append(elems, bson.E{Key: iter.Key().String(), Value: iter.Value().Interface()})
+		}
+		return elems, nil
+	}
+	return nil, ErrNotValidDocument
+}
+
+// mapToElements converts map to elements
+func mapToElements(m map[string]interface{}) []bson.E {
+	elems := make([]bson.E, 0, len(m))
+	for k, v := range m {
+		elems = append(elems, bson.E{Key: k, Value: v})
+	}
+	return elems
+}
@@ -0,0 +1,121 @@
+/*
+ Copyright 2020 The Qmgo Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package update
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDocument(t *testing.T) {
+	ast := require.New(t)
+
+	doc, err := Set("name", "Lucas").Inc("age", 1).Set("weight", 40).Unset("a", "b").Build()
+	ast.NoError(err)
+	expected := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "name", Value: "Lucas"}, {Key: "weight", Value: 40}}},
+		{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}},
+		{Key: "$unset", Value: bson.D{{Key: "a", Value: ""}, {Key: "b", Value: ""}}},
+	}
+	ast.Equal(expected, doc)
+
+	// same operator and field is overwritten
+	doc, err = Set("name", "Lucas").Set("name", "Alice").Build()
+	ast.NoError(err)
+	ast.Equal(bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Alice"}}}}, doc)
+
+	doc, err = New().Min("a", 1).Max("b", 2).Mul("c", 3).Rename("d", "e").CurrentDate("f").
+		SetOnInsert("g", 4).PopFirst("h").PopLast("i").Pull("j", bson.M{"$gt": 1}).PullAll("k", 1, 2).
+		BitAnd("l", 5).Build()
+	ast.NoError(err)
+	expected = bson.D{
+		{Key: "$min", Value: bson.D{{Key: "a", Value: 1}}},
+		{Key: "$max", Value: bson.D{{Key: "b", Value: 2}}},
+		{Key: "$mul", Value: bson.D{{Key: "c", Value: 3}}},
+		{Key: "$rename", Value: bson.D{{Key: "d", Value: "e"}}},
+		{Key: "$currentDate", Value: bson.D{{Key: "f", Value: true}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "g", Value: 4}}},
+		{Key: "$pop", Value: bson.D{{Key: "h", Value: -1}, {Key: "i", Value: 1}}},
+		{Key: "$pull", Value: bson.D{{Key: "j", Value: bson.M{"$gt": 1}}}},
+		{Key: "$pullAll", Value: bson.D{{Key: "k", Value: bson.A{1, 2}}}},
+		{Key: "$bit", Value: bson.D{{Key: "l", Value: bson.D{{Key: "and", Value: int64(5)}}}}},
+	}
+	ast.Equal(expected, doc)
+}
+
+func TestModifier(t *testing.T) {
+	ast := require.New(t)
+
+	doc, err := Push("tags", Each("a", "b").Position(0).Slice(-5).Sort(1)).
+		AddToSet("ids", Each(1, 2)).Build()
+	ast.NoError(err)
+	expected := bson.D{
+		{Key: "$push", Value: bson.D{{Key: "tags", Value: bson.D{
+			{Key: "$each", Value: bson.A{"a", "b"}},
+			{Key: "$position", Value: 0},
+			{Key: "$slice", Value: -5},
+			{Key: "$sort", Value: 1},
+		}}}},
+		{Key: "$addToSet", Value: bson.D{{Key: "ids", Value: bson.D{{Key: "$each", Value: bson.A{1, 2}}}}}},
+	}
+	ast.Equal(expected, doc)
+}
+
+func TestFrom(t *testing.T) {
+	ast := require.New(t)
+
+	doc, err := From(bson.D{{Key: "$set", Value: bson.M{"name": "Lucas"}}}).Inc("age", 1).Build()
+	ast.NoError(err)
+	ast.Equal(bson.D{
+		{Key: "$set", Value: bson.D{{Key: "name", Value: "Lucas"}}},
+		{Key: "$inc", Value: bson.D{{Key: "age", Value: 1}}},
+	}, doc)
+
+	// fields without operator are set
+	doc, err = From(bson.M{"name": "Lucas"}).Build()
+	ast.NoError(err)
+	ast.Equal(bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "Lucas"}}}}, doc)
+
+	// mixed
+	d := From(bson.M{"$set": bson.M{"name": "Lucas"}, "age": 7})
+	ast.Equal(ErrReplacementContainUpdateOperators, d.Err())
+	_, err = d.MarshalBSON()
+	ast.Equal(ErrReplacementContainUpdateOperators, err)
+
+	_, err = From(1).Build()
+	ast.Equal(ErrNotValidDocument, err)
+
+	// merge keeps the error
+	_, err = Set("a", 1).Merge(d).Build()
+	ast.Equal(ErrReplacementContainUpdateOperators, err)
+
+	doc, err = Set("a", 1).Merge(Set("b", 2).Inc("c", 1)).Build()
+	ast.NoError(err)
+	ast.Equal(bson.D{
+		{Key: "$set", Value: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}},
+		{Key: "$inc", Value: bson.D{{Key: "c", Value: 1}}},
+	}, doc)
+}
+
+func TestMarshalBSON(t *testing.T) {
+	ast := require.New(t)
+
+	b, err := bson.Marshal(Set("name", "Lucas"))
+	ast.NoError(err)
+	var m bson.M
+	ast.NoError(bson.Unmarshal(b, &m))
+	ast.Equal(bson.M{"$set": bson.M{"name": "Lucas"}}, m)
+}
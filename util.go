@@ -15,10 +15,16 @@ package qmgo
 
 import (
 	"math"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/operator"
+	"github.com/qiniu/qmgo/update"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -81,3 +87,182 @@ func CompareVersions(v1 string, v2 string) (int, error) {
 
 	return 0, nil
 }
+
+// andFilter returns the filter matching both filter and cond, filter can be nil
+func andFilter(filter interface{}, cond bson.M) interface{} {
+	if filter == nil {
+		return cond
+	}
+	return bson.M{operator.And: bson.A{filter, cond}}
+}
+
+// excludeDeleted returns the filter matching the documents of filter which are not soft deleted,
+// that is the deletion time field key is null or missing
+func excludeDeleted(filter interface{}, key string) interface{} {
+	return andFilter(filter, bson.M{key: nil})
+}
+
+// excludeDeletedPipeline returns the pipeline with the $match stage excluding the soft deleted documents
+// in front of it, or after its first stage if the stage must be the first one, like $geoNear
+// The pipeline reading no documents of collection, like the one starting with $collStats, is returned as it is
+// ErrNotValidPipeline is returned if pipeline is not a slice of stages
+func excludeDeletedPipeline(pipeline interface{}, key string) (interface{}, error) {
+	v := reflect.ValueOf(pipeline)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, ErrNotValidPipeline
+	}
+	stages := make(bson.A, 0, v.Len()+1)
+	for i := 0; i < v.Len(); i++ {
+		stages = append(stages, v.Index(i).Interface())
+	}
+	match := bson.D{{Key: operator.Match, Value: bson.M{key: nil}}}
+	if len(stages) == 0 {
+		return bson.A{match}, nil
+	}
+	first := stageName(stages[0])
+	if sourceStages[first] {
+		return pipeline, nil
+	}
+	if leadingStages[first] {
+		return append(bson.A{stages[0], match}, stages[1:]...), nil
+	}
+	return append(bson.A{match}, stages...), nil
+}
+
+// leadingStages must be the first stage of pipeline, the $match excluding the soft deleted documents is added
+// after them
+var leadingStages = map[string]bool{"$geoNear": true, "$search": true, "$vectorSearch": true}
+
+// sourceStages are the first stages which read no documents of collection
+var sourceStages = map[string]bool{
+	"$collStats": true, "$indexStats": true, "$documents": true, "$searchMeta": true, "$listSearchIndexes": true,
+	"$currentOp": true, "$listLocalSessions": true,
+}
+
+// stageName returns the name of pipeline stage, like "$match", or "" if it's not a document
+func stageName(stage interface{}) string {
+	raw, err := bson.Marshal(stage)
+	if err != nil {
+		return ""
+	}
+	elems, err := bson.Raw(raw).Elements()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	return elems[0].Key()
+}
+
+// toUpdateDocument returns the copy of u as update.Document, so operators can be added to it
+// u can be update.Document or the raw update document like bson.M and bson.D
+func toUpdateDocument(u interface{}) *update.Document {
+	if d, ok := u.(*update.Document); ok {
+		return update.New().Merge(d)
+	}
+	return update.From(u)
+}
+
+// versionFilter returns the condition matching the version field key equal to version, the version 0 matches
+// the documents without the field too, like the ones written before versioning or with omitempty
+func versionFilter(key string, version int64) bson.M {
+	if version == 0 {
+		return bson.M{key: bson.M{operator.In: bson.A{0, nil}}}
+	}
+	return bson.M{key: version}
+}
+
+// incVersion returns the copy of u increasing the version field key by 1
+func incVersion(u interface{}, key string) (interface{}, error) {
+	return toUpdateDocument(u).Inc(key, 1).Build()
+}
+
+// withUpdateFields returns the copy of update document u which sets the update time fields of model by $set,
+// and the id and create time fields of model by $setOnInsert if upsert is true, the fields set by u are kept.
+// u is encoded by registry, the default one is used if it's nil.
+// u is returned as it is if model is nil or u is not a document of update operators, like the update pipeline
+func withUpdateFields(registry *bsoncodec.Registry, model, filter, u interface{}, upsert bool) (interface{}, error) {
+	if model == nil || u == nil {
+		return u, nil
+	}
+	set := field.UpdateFields(model)
+	var setOnInsert bson.D
+	if upsert {
+		setOnInsert = field.InsertFields(model)
+	}
+	if len(set)+len(setOnInsert) == 0 {
+		return u, nil
+	}
+	if ut := reflect.TypeOf(u); (ut.Kind() == reflect.Slice || ut.Kind() == reflect.Array) &&
+		ut.Elem() != reflect.TypeOf(bson.E{}) {
+		// update pipeline
+		return u, nil
+	}
+	if registry == nil {
+		registry = bson.DefaultRegistry
+	}
+	raw, err := bson.MarshalWithRegistry(registry, u)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.UnmarshalWithRegistry(registry, raw, &doc); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range doc {
+		fields, ok := e.Value.(bson.D)
+		if !strings.HasPrefix(e.Key, "$") || !ok {
+			return u, nil
+		}
+		for _, f := range fields {
+			keys = append(keys, f.Key)
+		}
+	}
+	d := update.From(doc)
+	for _, e := range set {
+		if !pathConflicts(keys, e.Key) {
+			d.Set(e.Key, e.Value)
+		}
+	}
+	for _, e := range setOnInsert {
+		if !pathConflicts(keys, e.Key) && !(e.Key == "_id" && filterHasId(filter)) {
+			d.SetOnInsert(e.Key, e.Value)
+		}
+	}
+	return d, nil
+}
+
+// pathConflicts reports whether key is one of keys, or the parent or child path of one of them
+func pathConflicts(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key || strings.HasPrefix(key, k+".") || strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterHasId reports whether filter has the condition of _id, at top level or in $and
+func filterHasId(filter interface{}) bool {
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return false
+	}
+	if _, err = bson.Raw(raw).LookupErr("_id"); err == nil {
+		return true
+	}
+	and, err := bson.Raw(raw).LookupErr(operator.And)
+	if err != nil {
+		return false
+	}
+	conds, ok := and.ArrayOK()
+	if !ok {
+		return false
+	}
+	values, _ := conds.Values()
+	for _, v := range values {
+		if cond, ok := v.DocumentOK(); ok && filterHasId(cond) {
+			return true
+		}
+	}
+	return false
+}
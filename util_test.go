@@ -15,10 +15,17 @@ package qmgo
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/qiniu/qmgo/update"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func TestNow(t *testing.T) {
@@ -44,3 +51,132 @@ func TestCompareVersions(t *testing.T) {
 	ast.NoError(err)
 	ast.True(i < 0)
 }
+
+func TestExcludeDeleted(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal(bson.M{"deletedAt": nil}, excludeDeleted(nil, "deletedAt"))
+	ast.Equal(bson.M{"$and": bson.A{bson.M{"name": "Lucas"}, bson.M{"deletedAt": nil}}},
+		excludeDeleted(bson.M{"name": "Lucas"}, "deletedAt"))
+
+	match := bson.D{{Key: "$match", Value: bson.M{"deletedAt": nil}}}
+	p, err := excludeDeletedPipeline(NewPipeline().Limit(1), "deletedAt")
+	ast.NoError(err)
+	ast.Equal(bson.A{match, bson.D{{Key: "$limit", Value: int64(1)}}}, p)
+	p, err = excludeDeletedPipeline([]bson.M{{"$limit": 1}}, "deletedAt")
+	ast.NoError(err)
+	ast.Equal(bson.A{match, bson.M{"$limit": 1}}, p)
+	p, err = excludeDeletedPipeline(mongo.Pipeline{}, "deletedAt")
+	ast.NoError(err)
+	ast.Equal(bson.A{match}, p)
+
+	// the stage which must be the first one
+	geoNear := bson.M{"$geoNear": bson.M{"near": bson.A{0, 0}, "distanceField": "dist"}}
+	p, err = excludeDeletedPipeline(bson.A{geoNear, bson.M{"$limit": 1}}, "deletedAt")
+	ast.NoError(err)
+	ast.Equal(bson.A{geoNear, match, bson.M{"$limit": 1}}, p)
+	// the stage reading no documents of collection
+	stats := []bson.M{{"$collStats": bson.M{"count": bson.M{}}}}
+	p, err = excludeDeletedPipeline(stats, "deletedAt")
+	ast.NoError(err)
+	ast.Equal(stats, p)
+
+	_, err = excludeDeletedPipeline(bson.M{"$limit": 1}, "deletedAt")
+	ast.Equal(ErrNotValidPipeline, err)
+	_, err = excludeDeletedPipeline([]byte("[]"), "deletedAt")
+	ast.Equal(ErrNotValidPipeline, err)
+}
+
+func TestIncVersion(t *testing.T) {
+	ast := require.New(t)
+
+	ast.Equal(bson.M{"version": int64(3)}, versionFilter("version", 3))
+	ast.Equal(bson.M{"version": bson.M{"$in": bson.A{0, nil}}}, versionFilter("version", 0))
+
+	u, err := incVersion(bson.M{"$set": bson.M{"name": "Lucas"}}, "version")
+	ast.NoError(err)
+	ast.Equal(bson.D{
+		{Key: "$set", Value: bson.D{{Key: "name", Value: "Lucas"}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}, u)
+
+	d := update.Set("name", "Lucas")
+	u, err = incVersion(d, "version")
+	ast.NoError(err)
+	ast.Len(u, 2)
+	built, err := d.Build()
+	ast.NoError(err)
+	ast.Len(built, 1)
+
+	_, err = incVersion(bson.A{bson.M{"$set": bson.M{"name": "Lucas"}}}, "version")
+	ast.Equal(update.ErrNotValidDocument, err)
+}
+
+func TestWithUpdateFields(t *testing.T) {
+	ast := require.New(t)
+	updateFields := func(registry *bsoncodec.Registry, filter, u interface{}, upsert bool) bson.D {
+		res, err := withUpdateFields(registry, &UserField{}, filter, u, upsert)
+		ast.NoError(err)
+		doc, err := res.(*update.Document).Build()
+		ast.NoError(err)
+		return doc
+	}
+
+	u := bson.M{"$set": bson.M{"name": "Lucas"}}
+	res, err := withUpdateFields(nil, nil, nil, u, false)
+	ast.NoError(err)
+	ast.Equal(u, res)
+
+	doc := updateFields(nil, nil, u, false)
+	ast.Len(doc, 1)
+	set := doc[0].Value.(bson.D)
+	ast.Equal("$set", doc[0].Key)
+	ast.Equal([]string{"name", "updateAt", "updateTimeAt"}, elemKeys(set))
+
+	// the fields set by update are kept
+	doc = updateFields(nil, nil, bson.M{
+		"$set":         bson.M{"updateAt": time.Time{}},
+		"$setOnInsert": bson.M{"createAt.x": 1},
+	}, true)
+	m := doc.Map()
+	ast.Equal([]string{"updateAt", "updateTimeAt"}, elemKeys(m["$set"].(bson.D)))
+	ast.Equal([]string{"createAt.x", "_id", "myId", "createTimeAt"}, elemKeys(m["$setOnInsert"].(bson.D)))
+
+	// _id in filter is not set on insert
+	doc = updateFields(nil, bson.M{"$and": bson.A{bson.M{"_id": 1}}}, u, true)
+	ast.Equal([]string{"createAt", "myId", "createTimeAt"}, elemKeys(doc.Map()["$setOnInsert"].(bson.D)))
+
+	// not operator document
+	pipeline := bson.A{bson.M{"$set": bson.M{"name": "Lucas"}}}
+	res, err = withUpdateFields(nil, &UserField{}, nil, pipeline, false)
+	ast.NoError(err)
+	ast.Equal(pipeline, res)
+	replacement := bson.M{"name": "Lucas"}
+	res, err = withUpdateFields(nil, &UserField{}, nil, replacement, false)
+	ast.NoError(err)
+	ast.Equal(replacement, res)
+
+	// the update is encoded by the registry
+	registry := bson.NewRegistry()
+	registry.RegisterTypeEncoder(reflect.TypeOf(upperString("")), bsoncodec.ValueEncoderFunc(
+		func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, v reflect.Value) error {
+			return vw.WriteString(strings.ToUpper(v.String()))
+		}))
+	doc = updateFields(registry, nil, bson.M{"$set": bson.M{"name": upperString("lucas")}}, false)
+	ast.Equal("LUCAS", doc.Map()["$set"].(bson.D).Map()["name"])
+
+	// the error of encoding is returned
+	_, err = withUpdateFields(nil, &UserField{}, nil, bson.M{"$set": bson.M{"name": make(chan int)}}, false)
+	ast.Error(err)
+}
+
+// upperString is encoded in upper case by the registry in TestWithUpdateFields
+type upperString string
+
+func elemKeys(d bson.D) []string {
+	keys := make([]string, 0, len(d))
+	for _, e := range d {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
@@ -0,0 +1,119 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/qiniu/qmgo/field"
+)
+
+// FieldError is the validation failure of one field
+type FieldError struct {
+	// Field is the path of struct field, like "Addresses[0].City"
+	Field string
+	// BsonKey is the dotted bson key of the field, like "addresses.0.city"
+	BsonKey string
+	// Tag is the validation tag failed, like "required"
+	Tag string
+	// Param is the param of tag, like "130" of "lte=130"
+	Param string
+	// Value is the value of the field
+	Value interface{}
+	// Message is the message made by Translator
+	Message string
+}
+
+// ValidationError is returned if the document or the fields of update are invalid
+// It unwraps to validator.ValidationErrors of go-playground validator
+type ValidationError struct {
+	Errors []FieldError
+
+	err validator.ValidationErrors
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the validator.ValidationErrors of go-playground validator
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// Translator makes the message of FieldError, like translating by its Tag and Param
+type Translator func(fe FieldError) string
+
+// translator is the Translator used to make messages
+var translator Translator = defaultMessage
+
+// SetTranslator sets the Translator making the message of FieldError, nil restores the default one
+func SetTranslator(t Translator) {
+	if t == nil {
+		t = defaultMessage
+	}
+	translator = t
+}
+
+// defaultMessage returns the message like "age failed on the 'lte' tag"
+func defaultMessage(fe FieldError) string {
+	return fmt.Sprintf("%s failed on the '%s' tag", fe.BsonKey, fe.Tag)
+}
+
+// validationError appends the errors of go-playground validator to e, the fields are the ones of struct
+// type t under the field path and bson key prefix, e is returned as it is if err is not validator.ValidationErrors
+func validationError(e *ValidationError, err error, t reflect.Type, path, key string) (*ValidationError, error) {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return e, err
+	}
+	if e == nil {
+		e = &ValidationError{}
+	}
+	for _, fe := range errs {
+		fieldPath, fieldKey := path, key
+		// the namespace starts with the name of struct
+		if i := strings.Index(fe.StructNamespace(), "."); i >= 0 && t != nil {
+			ns := fe.StructNamespace()[i+1:]
+			fieldPath = joinPath(path, ns)
+			fieldKey = joinPath(key, field.BsonPath(t, ns))
+		}
+		f := FieldError{Field: fieldPath, BsonKey: fieldKey, Tag: fe.Tag(), Param: fe.Param(), Value: fe.Value()}
+		f.Message = translator(f)
+		e.Errors = append(e.Errors, f)
+		e.err = append(e.err, fe)
+	}
+	return e, nil
+}
+
+// sortErrors sorts the errors of e by the bson keys
+func (e *ValidationError) sortErrors() {
+	sort.SliceStable(e.Errors, func(i, j int) bool { return e.Errors[i].BsonKey < e.Errors[j].BsonKey })
+}
+
+// joinPath joins the paths by "."
+func joinPath(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+	if path == "" {
+		return prefix
+	}
+	return prefix + "." + path
+}
+
+// indirectType returns the type t points to
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
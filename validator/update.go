@@ -0,0 +1,189 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/qmgo/field"
+	"github.com/qiniu/qmgo/operator"
+	"github.com/qiniu/qmgo/update"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Update validates the fields set by $set and $setOnInsert of update document u against the validate tags of
+// the fields of model, the values are decoded into the types of model fields, and struct values are validated
+// as documents too
+// u is marshaled like the driver does, so it can be update.Document, map, bson.D or struct, the document without
+// update operators is treated as the fields to $set. The fields not in model and update pipelines are skipped.
+func Update(model, u interface{}) error {
+	if model == nil || u == nil {
+		return nil
+	}
+	t := indirectType(reflect.TypeOf(model))
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if ut := reflect.TypeOf(u); (ut.Kind() == reflect.Slice || ut.Kind() == reflect.Array) &&
+		ut.Elem() != reflect.TypeOf(bson.E{}) {
+		// update pipeline
+		return nil
+	}
+	raw, err := bson.Marshal(u)
+	if err != nil {
+		return err
+	}
+	elems, err := bson.Raw(raw).Elements()
+	if err != nil {
+		return err
+	}
+	operators := 0
+	for _, op := range elems {
+		if strings.HasPrefix(op.Key(), "$") {
+			operators++
+		}
+	}
+	if operators == 0 {
+		return validateFields(t, elems)
+	}
+	if operators != len(elems) {
+		return update.ErrReplacementContainUpdateOperators
+	}
+	var e *ValidationError
+	for _, op := range elems {
+		if op.Key() != operator.Set && op.Key() != operator.SetOnInsert {
+			continue
+		}
+		fields, ok := op.Value().DocumentOK()
+		if !ok {
+			return update.ErrNotValidDocument
+		}
+		if e, err = appendFields(e, t, fields); err != nil {
+			return err
+		}
+	}
+	return e.orNil()
+}
+
+// validateFields validates the fields of $set
+func validateFields(t reflect.Type, elems []bson.RawElement) error {
+	var e *ValidationError
+	for _, f := range elems {
+		var err error
+		if e, err = validateField(e, t, f.Key(), f.Value()); err != nil {
+			return err
+		}
+	}
+	return e.orNil()
+}
+
+// appendFields validates the fields of document doc and appends the errors to e
+func appendFields(e *ValidationError, t reflect.Type, doc bson.Raw) (*ValidationError, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return e, err
+	}
+	for _, f := range elems {
+		if e, err = validateField(e, t, f.Key(), f.Value()); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// orNil returns e sorted by the bson keys, or nil error if there is no error in e
+func (e *ValidationError) orNil() error {
+	if e == nil {
+		return nil
+	}
+	e.sortErrors()
+	return e
+}
+
+// validateField decodes raw into the type of the field of t at the dotted bson key, validates it by
+// the validate tag of the field, and appends the errors to e
+func validateField(e *ValidationError, t reflect.Type, key string, raw bson.RawValue) (*ValidationError, error) {
+	path, tag, ft, ok := lookupKey(t, key)
+	if !ok {
+		return e, nil
+	}
+	v := reflect.New(ft)
+	if err := raw.Unmarshal(v.Interface()); err != nil {
+		return e, fmt.Errorf("cannot decode %s into %s: %w", key, ft, err)
+	}
+	value := v.Elem().Interface()
+	if tag != "" && tag != "-" {
+		var err error
+		if e, err = validationError(e, validate.Var(value, tag), nil, path, key); err != nil {
+			return e, err
+		}
+	}
+	if !validatorStruct(value) {
+		return e, nil
+	}
+	return validationError(e, validate.Struct(value), indirectType(ft), path, key)
+}
+
+// lookupKey returns the path, validate tag and type of the field of struct t at the dotted bson key
+// The numbers and positional operators like $[] in key are the elements of slices and maps, the validate tags
+// of which are the ones after dive of their parents
+func lookupKey(t reflect.Type, key string) (path, tag string, ft reflect.Type, ok bool) {
+	ft = t
+	for _, seg := range strings.Split(key, ".") {
+		ft = indirectType(ft)
+		switch ft.Kind() {
+		case reflect.Struct:
+			name, sf, found := fieldByKey(ft, seg)
+			if !found {
+				return "", "", nil, false
+			}
+			path = joinPath(path, name)
+			tag = sf.Tag.Get("validate")
+			ft = sf.Type
+		case reflect.Slice, reflect.Array, reflect.Map:
+			if _, err := strconv.Atoi(seg); err != nil && !strings.HasPrefix(seg, "$") && ft.Kind() != reflect.Map {
+				return "", "", nil, false
+			}
+			path += "[" + seg + "]"
+			tag = diveTag(tag)
+			ft = ft.Elem()
+		default:
+			return "", "", nil, false
+		}
+	}
+	return path, tag, ft, true
+}
+
+// fieldByKey returns the field of struct t with the bson key and its name path, the fields of inline structs
+// are searched too, like "Base.CreateAt"
+func fieldByKey(t reflect.Type, key string) (string, reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		k, inline := field.BsonName(sf)
+		if k == "-" {
+			continue
+		}
+		if inline {
+			if et := indirectType(sf.Type); et.Kind() == reflect.Struct {
+				if name, f, ok := fieldByKey(et, key); ok {
+					return sf.Name + "." + name, f, true
+				}
+			}
+			continue
+		}
+		if k == key {
+			return sf.Name, sf, true
+		}
+	}
+	return "", reflect.StructField{}, false
+}
+
+// diveTag returns the validate tag of elements in tag, which is the one after dive
+func diveTag(tag string) string {
+	i := strings.Index(tag, "dive")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimPrefix(tag[i+len("dive"):], ",")
+}
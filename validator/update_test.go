@@ -0,0 +1,109 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/qiniu/qmgo/operator"
+	"github.com/qiniu/qmgo/update"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Base struct {
+	CreateAt time.Time `bson:"createAt" validate:"lte"`
+}
+
+type Profile struct {
+	Base      `bson:",inline"`
+	Age       uint8      `bson:"age" validate:"gte=0,lte=130"`
+	Email     string     `bson:"e-mail" validate:"required,email"`
+	Tags      []string   `bson:"tags" validate:"max=2,dive,required"`
+	Addresses []*Address `bson:"addresses" validate:"dive"`
+	Home      *Address   `bson:"home"`
+}
+
+func TestUpdate(t *testing.T) {
+	ast := require.New(t)
+	SetValidate(validator.New())
+
+	ast.NoError(Update(nil, bson.M{"$set": bson.M{"age": 200}}))
+	ast.NoError(Update(&Profile{}, nil))
+	ast.NoError(Update(bson.M{}, bson.M{"$set": bson.M{"age": 200}}))
+	ast.NoError(Update(&Profile{}, bson.A{bson.M{"$set": bson.M{"age": 200}}}))
+	ast.NoError(Update(&Profile{}, bson.M{"$set": bson.M{"age": 20, "unknown": 1, "tags.0": "a"}, "$inc": bson.M{"age": 200}}))
+	ast.NoError(Update(Profile{}, update.Set("e-mail", "1234@gmail.com").SetOnInsert("createAt", time.Now())))
+
+	err := Update(&Profile{}, bson.D{
+		{Key: "$set", Value: bson.M{"age": 200, "e-mail": "1234@gmail", "tags": []string{"a", "b", "c"}, "tags.1": ""}},
+		{Key: "$setOnInsert", Value: bson.M{"createAt": time.Now().Add(time.Hour)}},
+	})
+	var e *ValidationError
+	ast.True(errors.As(err, &e))
+	ast.Equal([]FieldError{
+		{Field: "Age", BsonKey: "age", Tag: "lte", Param: "130", Value: uint8(200), Message: "age failed on the 'lte' tag"},
+		{Field: "Base.CreateAt", BsonKey: "createAt", Tag: "lte", Value: e.Errors[1].Value, Message: "createAt failed on the 'lte' tag"},
+		{Field: "Email", BsonKey: "e-mail", Tag: "email", Value: "1234@gmail", Message: "e-mail failed on the 'email' tag"},
+		{Field: "Tags", BsonKey: "tags", Tag: "max", Param: "2", Value: []string{"a", "b", "c"}, Message: "tags failed on the 'max' tag"},
+		{Field: "Tags[1]", BsonKey: "tags.1", Tag: "required", Value: "", Message: "tags.1 failed on the 'required' tag"},
+	}, e.Errors)
+	var errs validator.ValidationErrors
+	ast.True(errors.As(err, &errs))
+	ast.Len(errs, 5)
+
+	// partial struct and the document without operators
+	partial := struct {
+		Age   int    `bson:"age"`
+		Email string `bson:"e-mail,omitempty"`
+	}{Age: 200}
+	err = Update(&Profile{}, bson.M{"$set": partial})
+	ast.True(errors.As(err, &e))
+	ast.Len(e.Errors, 1)
+	ast.Equal("age", e.Errors[0].BsonKey)
+	ast.True(errors.As(Update(&Profile{}, partial), &e))
+	ast.Equal("age", e.Errors[0].BsonKey)
+
+	// the update can't be understood
+	ast.Error(Update(&Profile{}, bson.M{"$set": bson.M{"age": "old"}}))
+	ast.Error(Update(&Profile{}, bson.M{"$set": 1}))
+	ast.Equal(update.ErrReplacementContainUpdateOperators, Update(&Profile{}, bson.M{"$set": bson.M{"age": 1}, "age": 2}))
+	ast.Error(Update(&Profile{}, 1))
+
+	// struct values and their elements
+	err = Update(&Profile{}, bson.M{"$set": bson.M{
+		"home":          &Address{Street: "Eavesdown Docks", Planet: "Persphone", Phone: "none"},
+		"addresses.$[]": Address{City: "Unknown", Planet: "Persphone", Phone: "none"},
+	}})
+	ast.True(errors.As(err, &e))
+	ast.Len(e.Errors, 2)
+	ast.Equal("Addresses[$[]].Street", e.Errors[0].Field)
+	ast.Equal("addresses.$[].street", e.Errors[0].BsonKey)
+	ast.Equal("Home.City", e.Errors[1].Field)
+	ast.Equal("home.city", e.Errors[1].BsonKey)
+
+	// translator
+	SetTranslator(func(fe FieldError) string { return fe.Field + " is invalid" })
+	defer SetTranslator(nil)
+	err = Update(&Profile{}, bson.M{"$set": bson.M{"age": 200}})
+	ast.EqualError(err, "validation failed: Age is invalid")
+}
+
+func TestValidationError(t *testing.T) {
+	ast := require.New(t)
+	SetValidate(validator.New())
+
+	user := &User{Age: 150, Email: "1234@gmail.com", FavouriteColor: "#000",
+		Addresses: []*Address{{Street: "Eavesdown Docks", Planet: "Persphone", Phone: "none"}}}
+	err := Do(context.Background(), user, operator.BeforeInsert)
+	var e *ValidationError
+	ast.True(errors.As(err, &e))
+	ast.Len(e.Errors, 2)
+	ast.Equal(FieldError{Field: "Age", BsonKey: "age", Tag: "lte", Param: "130", Value: uint8(150),
+		Message: "age failed on the 'lte' tag"}, e.Errors[0])
+	ast.Equal("Addresses[0].City", e.Errors[1].Field)
+	ast.Equal("addresses.0.city", e.Errors[1].BsonKey)
+	ast.Equal("validation failed: age failed on the 'lte' tag; addresses.0.city failed on the 'required' tag", err.Error())
+}
@@ -74,12 +74,20 @@ func sliceHandle(docs interface{}, opType operator.OpType) error {
 	return nil
 }
 
-// do check if opType is supported and call fieldHandler
+// do validates the struct doc, the error is *ValidationError which unwraps to validator.ValidationErrors
 func do(doc interface{}) error {
 	if !validatorStruct(doc) {
 		return nil
 	}
-	return validate.Struct(doc)
+	err := validate.Struct(doc)
+	if err == nil {
+		return nil
+	}
+	e, err := validationError(nil, err, reflect.TypeOf(doc), "", "")
+	if err != nil {
+		return err
+	}
+	return e
 }
 
 // validatorStruct check if kind of doc is validator supported struct
@@ -2,10 +2,13 @@ package qmgo
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"testing"
 	"time"
 
+	"github.com/go-playground/validator/v10"
+	"github.com/qiniu/qmgo/options"
 	"github.com/stretchr/testify/require"
 )
 
@@ -37,7 +40,12 @@ func TestValidator(t *testing.T) {
 
 	user.Age = 200 // invalid age
 	_, err = cli.InsertOne(ctx, user)
-	ast.Error(err)
+	var e *ValidationError
+	ast.True(errors.As(err, &e))
+	ast.Equal("age", e.Errors[0].BsonKey)
+	var errs validator.ValidationErrors
+	ast.True(errors.As(err, &errs))
+	ast.Equal("lte", errs[0].Tag())
 
 	users := []*User{user, user, user}
 	_, err = cli.InsertMany(ctx, users)
@@ -66,3 +74,44 @@ func TestValidator(t *testing.T) {
 	_, err = cli.Upsert(ctx, bson.M{"age": 45}, user)
 	ast.Error(err)
 }
+
+func TestValidatorUpdate(t *testing.T) {
+	ast := require.New(t)
+	cli := initClient("test")
+	ctx := context.Background()
+	defer cli.Close(ctx)
+	coll := cli.Database.Collection("test_validator_update", &options.CollectionOptions{Model: &User{}})
+	defer coll.DropCollection(ctx)
+
+	_, err := coll.InsertOne(ctx, &User{FirstName: "Alice", Age: 45, Email: "1234@gmail.com"})
+	ast.NoError(err)
+
+	ast.NoError(coll.UpdateOne(ctx, bson.M{"fname": "Alice"}, bson.M{"$set": bson.M{"age": 46}}))
+
+	var e *ValidationError
+	err = coll.UpdateOne(ctx, bson.M{"fname": "Alice"}, bson.M{"$set": bson.M{"age": 200, "e-mail": "1234@gmail"}})
+	ast.True(errors.As(err, &e))
+	ast.Len(e.Errors, 2)
+	ast.Equal("age", e.Errors[0].BsonKey)
+	ast.Equal("lte", e.Errors[0].Tag)
+	ast.Equal("e-mail", e.Errors[1].BsonKey)
+	ast.Equal("email", e.Errors[1].Tag)
+
+	_, err = coll.UpdateAll(ctx, bson.M{}, bson.M{"$set": bson.M{"relations": map[string]string{"a": "", "b": "", "c": ""}}})
+	ast.True(errors.As(err, &e))
+
+	// Bulk
+	_, err = coll.Bulk().UpdateOne(bson.M{"fname": "Alice"}, bson.M{"$set": bson.M{"age": 200}}).Run(ctx)
+	ast.True(errors.As(err, &e))
+	_, err = coll.Bulk().UpdateOne(bson.M{"fname": "Alice"}, bson.M{"$set": bson.M{"age": 200}},
+		options.BulkOperationOptions{SkipMiddleware: true}).Run(ctx)
+	ast.NoError(err)
+
+	// Apply
+	var res User
+	err = coll.Find(ctx, bson.M{"fname": "Alice"}).Apply(Change{Update: bson.M{"$set": bson.M{"e-mail": ""}}}, &res)
+	ast.True(errors.As(err, &e))
+	err = coll.Find(ctx, bson.M{"fname": "Alice"}).Apply(Change{Replace: true, Update: &User{FirstName: "Alice", Age: 200}}, &res)
+	ast.True(errors.As(err, &e))
+	ast.NoError(coll.Find(ctx, bson.M{"fname": "Alice"}).Apply(Change{Update: bson.M{"$set": bson.M{"age": 20}}}, &res))
+}